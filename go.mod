@@ -7,18 +7,24 @@ go 1.25.1
 // 	golang.org/x/net v0.34.0 // indirect
 // )
 
-require github.com/dmartsapp/go-ping v1.1.1
+require (
+	github.com/quic-go/quic-go v0.59.1
+	golang.org/x/net v0.44.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	// github.com/dmartsapp/telnet v1.8.0
 	github.com/spf13/cobra v1.10.1
-	github.com/spf13/pflag v1.0.10 // indirect
+	github.com/spf13/pflag v1.0.10
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	golang.org/x/net v0.44.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/crypto v0.42.0 // indirect
 	golang.org/x/sys v0.36.0 // indirect
+	golang.org/x/text v0.29.0 // indirect
 )
 
 // replace github.com/dmartsapp/go-ping => ./go-ping/
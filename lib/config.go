@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of the configuration file. Every setting is keyed by
+// the long name of the command line flag it provides a default for, so that
+// the file reads like the flags it saves typing.
+type Config struct {
+	Defaults map[string]any            `json:"defaults"` // applied to every command
+	Commands map[string]map[string]any `json:"commands"` // keyed by command, e.g. "web" or "nmap diff"
+	Profiles map[string]Profile        `json:"profiles"`
+}
+
+// Profile is a named bundle selected with --profile: the command and its
+// positional arguments (the target), flags, and expectations on the result.
+type Profile struct {
+	Command string         `json:"command"`
+	Args    []string       `json:"args"`
+	Flags   map[string]any `json:"flags"`
+	Expect  *PlanExpect    `json:"expect"`
+}
+
+// DefaultConfigPath returns ~/.config/shint/config.yaml, or the same file
+// under $XDG_CONFIG_HOME when it is set.
+func DefaultConfigPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "shint", "config.yaml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "config.yaml"
+	}
+	return filepath.Join(home, ".config", "shint", "config.yaml")
+}
+
+// LoadConfig reads a YAML or JSON configuration file. A missing file is only
+// an error when required is set, i.e. when the path was given explicitly.
+func LoadConfig(path string, required bool) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	var generic any
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	data, err = json.Marshal(generic)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Profile looks up a named profile.
+func (config Config) Profile(name string) (Profile, error) {
+	profile, ok := config.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(config.Profiles))
+		for known := range config.Profiles {
+			names = append(names, known)
+		}
+		if len(names) == 0 {
+			return profile, fmt.Errorf("unknown profile '%s', the configuration file defines none", name)
+		}
+		sort.Strings(names)
+		return profile, fmt.Errorf("unknown profile '%s', known profiles: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// Layers returns the flag defaults that apply to command, highest precedence
+// first: the profile (if any), the section of the command, the defaults.
+func (config Config) Layers(command string, profile string) ([]map[string]any, error) {
+	layers := make([]map[string]any, 0, 3)
+	if profile != "" {
+		selected, err := config.Profile(profile)
+		if err != nil {
+			return nil, err
+		}
+		if selected.Command != "" && selected.Command != command {
+			return nil, fmt.Errorf("profile '%s' is for the %s command, not %s", profile, selected.Command, command)
+		}
+		layers = append(layers, selected.Flags)
+	}
+	return append(layers, config.Commands[command], config.Defaults), nil
+}
+
+// Sources of the flag values set by ApplyFlags.
+const (
+	ConfigEnvironment = "environment"
+	ConfigProfile     = "profile"
+	ConfigCommand     = "command"
+	ConfigDefaults    = "defaults"
+)
+
+// ApplyFlags fills the flags of command that were not given on the command
+// line from, in order of precedence, SHINT_* environment variables, the
+// profile, the section of the command and the defaults, and returns where
+// each value came from. The values are set without marking the flags changed,
+// so that Changed keeps telling the flags of the command line apart.
+func (config Config) ApplyFlags(flags *pflag.FlagSet, command string, profile string) (map[string]string, error) {
+	applied := make(map[string]string)
+	layers, err := config.Layers(command, profile)
+	if err != nil {
+		return applied, err
+	}
+	sources := []string{ConfigCommand, ConfigDefaults}
+	if profile != "" {
+		sources = append([]string{ConfigProfile}, sources...)
+		for name := range layers[0] {
+			if flags.Lookup(name) == nil {
+				return applied, fmt.Errorf("profile '%s' sets --%s, which %s does not have", profile, name, command)
+			}
+		}
+	}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "config" || flag.Name == "profile" {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(flag.Name)); ok {
+			applied[flag.Name], err = ConfigEnvironment, setConfigured(flag, value)
+			return
+		}
+		for i, layer := range layers {
+			if value, ok := layer[flag.Name]; ok {
+				applied[flag.Name] = sources[i]
+				for _, value := range FlagValues(value) {
+					if err = setConfigured(flag, value); err != nil {
+						return
+					}
+				}
+				return
+			}
+		}
+	})
+	return applied, err
+}
+
+// setConfigured sets a flag to a configured value the way the command line
+// would, but leaves it unchanged.
+func setConfigured(flag *pflag.Flag, value string) error {
+	if err := flag.Value.Set(value); err != nil {
+		return fmt.Errorf("invalid value '%s' for --%s: %w", value, flag.Name, err)
+	}
+	return nil
+}
+
+// EnvName returns the environment variable that provides a default for a
+// flag, e.g. SHINT_SUCCESS_THRESHOLD for --success-threshold.
+func EnvName(flag string) string {
+	return "SHINT_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// FlagValues renders a configuration value as the strings to set its flag to,
+// one per element for lists so that repeatable flags such as --header work.
+func FlagValues(value any) []string {
+	switch value := value.(type) {
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, element := range value {
+			values = append(values, FlagValues(element)...)
+		}
+		return values
+	case float64:
+		return []string{strconv.FormatFloat(value, 'f', -1, 64)}
+	case nil:
+		return []string{}
+	}
+	return []string{fmt.Sprint(value)}
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// TestLoadConfig checks the layers of flag defaults and profile lookup.
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+defaults:
+  timeout: 3
+commands:
+  web:
+    header: ["accept: application/json"]
+profiles:
+  prod-api:
+    command: web
+    args: [https://api.example.com/health]
+    flags:
+      count: 5
+    expect:
+      status_code: 200
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := loaded.Layers("web", "prod-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 3 || layers[0]["count"] != 5.0 || layers[2]["timeout"] != 3.0 {
+		t.Errorf("Unexpected layers %v", layers)
+	}
+	if values := FlagValues(layers[1]["header"]); !slices.Equal(values, []string{"accept: application/json"}) {
+		t.Errorf("Unexpected header values %v", values)
+	}
+	profile, _ := loaded.Profile("prod-api")
+	if profile.Expect == nil || profile.Expect.StatusCode != 200 || profile.Args[0] != "https://api.example.com/health" {
+		t.Errorf("Unexpected profile %+v", profile)
+	}
+	if _, err := loaded.Layers("telnet", "prod-api"); err == nil {
+		t.Error("A web profile should not apply to telnet")
+	}
+	if _, err := loaded.Layers("web", "staging"); err == nil {
+		t.Error("An unknown profile should be rejected")
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), false); err != nil {
+		t.Errorf("A missing default configuration should be ignored, got %v", err)
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), true); err == nil {
+		t.Error("A missing explicit configuration should be an error")
+	}
+}
+
+// TestApplyFlags checks the precedence of the command line, the environment,
+// the profile, the command section and the defaults, and that configured
+// flags are not reported as changed.
+func TestApplyFlags(t *testing.T) {
+	config := Config{
+		Defaults: map[string]any{"timeout": 6.0, "count": 6.0, "delay": 6.0, "header": []any{"x-default: 1"}, "payload": 64.0},
+		Commands: map[string]map[string]any{"web": {"timeout": 7.0, "count": 7.0, "delay": 7.0, "header": []any{"accept: application/json", "x-web: 1"}}},
+		Profiles: map[string]Profile{"prod": {Command: "web", Flags: map[string]any{"timeout": 8.0, "count": 8.0, "delay": 8.0}}},
+	}
+	flags := pflag.NewFlagSet("web", pflag.ContinueOnError)
+	timeout, count, delay := flags.Int("timeout", 5, ""), flags.Int("count", 1, ""), flags.Int("delay", 1000, "")
+	payload, headers := flags.Int("payload", 4, ""), flags.StringArray("header", []string{}, "")
+	if err := flags.Parse([]string{"--timeout", "10"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHINT_TIMEOUT", "9")
+	t.Setenv("SHINT_COUNT", "9")
+	applied, err := config.ApplyFlags(flags, "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *timeout != 10 || *count != 9 || *delay != 8 || *payload != 64 {
+		t.Errorf("Expected the flag, environment, profile and default values, got timeout %d, count %d, delay %d and payload %d", *timeout, *count, *delay, *payload)
+	}
+	if !slices.Equal(*headers, []string{"accept: application/json", "x-web: 1"}) {
+		t.Errorf("Expected the headers of the web section, got %v", *headers)
+	}
+	if !flags.Changed("timeout") || flags.Changed("count") || flags.Changed("payload") {
+		t.Error("Expected only the flags of the command line to be changed")
+	}
+	expected := map[string]string{"count": ConfigEnvironment, "delay": ConfigProfile, "header": ConfigCommand, "payload": ConfigDefaults}
+	if !maps.Equal(applied, expected) {
+		t.Errorf("Expected the sources %v, got %v", expected, applied)
+	}
+
+	config.Profiles["prod"].Flags["expect"] = "ok"
+	if _, err := config.ApplyFlags(pflag.NewFlagSet("web", pflag.ContinueOnError), "web", "prod"); err == nil {
+		t.Error("Expected an error for a profile setting a flag the command does not have")
+	}
+	t.Setenv("SHINT_COUNT", "many")
+	if _, err := config.ApplyFlags(flags, "web", ""); err == nil {
+		t.Error("Expected an error for an invalid environment value")
+	}
+}
@@ -0,0 +1,204 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// EchoPinger sends ICMP echo requests to one address over a single socket
+// and matches the replies by sequence number. Because the socket stays open
+// for the whole run, replies arriving more than once are counted as
+// duplicates instead of being lost with a per-request socket, as they were
+// with the single-shot pingers of go-ping.
+//
+// The sequence number on the wire has 16 bits, so a long run reuses it: every
+// request forgets the replies of the earlier request with its number, and
+// duplicates are counted by the full sequence number of the request.
+type EchoPinger struct {
+	conn       *icmp.PacketConn
+	raw        bool // raw sockets see every ICMP message of the host and filter by id
+	address    net.IP
+	id         int
+	MUTEX      sync.Mutex
+	waiting    map[int]chan time.Time
+	sequences  map[int]int // full sequence number of the last request sent with a 16-bit one
+	answered   map[int]bool
+	duplicates map[int]int // by full sequence number
+}
+
+// listenICMP opens an unprivileged ICMP datagram socket where the system
+// allows it, and a raw one otherwise.
+func listenICMP() (*icmp.PacketConn, bool, error) {
+	var datagramErr error
+	if runtime.GOOS != "windows" {
+		conn, err := icmp.ListenPacket("udp4", "0.0.0.0")
+		if err == nil {
+			return conn, false, nil
+		}
+		datagramErr = err
+	}
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, true, errors.Join(datagramErr, err)
+	}
+	return conn, true, nil
+}
+
+// ICMPAvailable tells why no ICMP socket can be opened, nil when pinging is
+// possible.
+func ICMPAvailable() error {
+	conn, _, err := listenICMP()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// NewEchoPinger opens the socket to ping the IPv4 address.
+func NewEchoPinger(address string) (*EchoPinger, error) {
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("'%s' is not an IPv4 address", address)
+	}
+	conn, raw, err := listenICMP()
+	if err != nil {
+		return nil, err
+	}
+	pinger := &EchoPinger{
+		conn:       conn,
+		raw:        raw,
+		address:    ip,
+		id:         int(time.Now().UnixNano() & 0xffff),
+		waiting:    make(map[int]chan time.Time),
+		sequences:  make(map[int]int),
+		answered:   make(map[int]bool),
+		duplicates: make(map[int]int),
+	}
+	go pinger.read()
+	return pinger, nil
+}
+
+// Ping sends the echo request of sequence with payload bytes of data and
+// waits up to timeout for its reply.
+func (pinger *EchoPinger) Ping(sequence int, payload int, timeout time.Duration) ICMPStats {
+	stat := ICMPStats{Address: pinger.address.String(), Sequence: sequence, PayloadSize: payload, Method: PingICMP}
+	seq := sequence & 0xffff
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: pinger.id, Seq: seq, Data: make([]byte, payload)},
+	}).Marshal(nil)
+	if err != nil {
+		stat.Error = err.Error()
+		return stat
+	}
+	reply := pinger.register(sequence)
+	defer func() {
+		pinger.MUTEX.Lock()
+		delete(pinger.waiting, seq)
+		pinger.MUTEX.Unlock()
+	}()
+
+	var destination net.Addr = &net.UDPAddr{IP: pinger.address}
+	if pinger.raw {
+		destination = &net.IPAddr{IP: pinger.address}
+	}
+	sent := time.Now()
+	stat.SentTime = sent.UnixMicro()
+	if _, err := pinger.conn.WriteTo(request, destination); err != nil {
+		stat.Error = err.Error()
+		return stat
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case received := <-reply:
+		stat.Success = true
+		stat.RecvTime = received.UnixMicro()
+		stat.TimeTaken = received.Sub(sent).Microseconds()
+	case <-timer.C:
+		stat.Error = "no reply within " + timeout.String()
+	}
+	return stat
+}
+
+// register makes the reply to the request of sequence expected, in place of
+// the replies to an earlier request with the same 16-bit sequence number.
+func (pinger *EchoPinger) register(sequence int) chan time.Time {
+	seq := sequence & 0xffff
+	reply := make(chan time.Time, 1)
+	pinger.MUTEX.Lock()
+	defer pinger.MUTEX.Unlock()
+	pinger.waiting[seq], pinger.sequences[seq] = reply, sequence
+	delete(pinger.answered, seq)
+	delete(pinger.duplicates, sequence)
+	return reply
+}
+
+// deliver hands the reply received for the 16-bit sequence number seq to the
+// Ping call waiting for it, or counts it as a duplicate once it was answered.
+func (pinger *EchoPinger) deliver(seq int, received time.Time) {
+	pinger.MUTEX.Lock()
+	defer pinger.MUTEX.Unlock()
+	if pinger.answered[seq] {
+		pinger.duplicates[pinger.sequences[seq]]++
+	} else if reply, ok := pinger.waiting[seq]; ok {
+		pinger.answered[seq] = true
+		reply <- received
+	}
+}
+
+// Duplicates returns the number of replies received for sequence after the
+// first one.
+func (pinger *EchoPinger) Duplicates(sequence int) int {
+	pinger.MUTEX.Lock()
+	defer pinger.MUTEX.Unlock()
+	return pinger.duplicates[sequence]
+}
+
+// Close closes the socket, which ends the reader.
+func (pinger *EchoPinger) Close() error {
+	return pinger.conn.Close()
+}
+
+// read delivers the echo replies to the Ping calls waiting for them until the
+// socket is closed.
+func (pinger *EchoPinger) read() {
+	buffer := make([]byte, 65536)
+	for {
+		n, peer, err := pinger.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		received := time.Now()
+		message, err := icmp.ParseMessage(1, buffer[:n]) // 1 is ICMP for IPv4
+		if err != nil || message.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		echo, ok := message.Body.(*icmp.Echo)
+		if !ok || !peerIP(peer).Equal(pinger.address) {
+			continue
+		}
+		if pinger.raw && echo.ID != pinger.id { // datagram sockets only get their own replies, with an id set by the system
+			continue
+		}
+		pinger.deliver(echo.Seq, received)
+	}
+}
+
+// peerIP returns the IP address of the sender of a packet.
+func peerIP(peer net.Addr) net.IP {
+	switch peer := peer.(type) {
+	case *net.UDPAddr:
+		return peer.IP
+	case *net.IPAddr:
+		return peer.IP
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+// TestEchoPingerDuplicates counts the replies after the first as duplicates
+// of their request and ignores replies nobody waits for.
+func TestEchoPingerDuplicates(t *testing.T) {
+	pinger := &EchoPinger{waiting: make(map[int]chan time.Time), sequences: make(map[int]int), answered: make(map[int]bool), duplicates: make(map[int]int)}
+	reply := pinger.register(1)
+	pinger.deliver(1, time.Now())
+	pinger.deliver(1, time.Now())
+	pinger.deliver(1, time.Now())
+	pinger.deliver(2, time.Now())
+	if len(reply) != 1 {
+		t.Errorf("Expected the first reply to be delivered, got %d", len(reply))
+	}
+	if pinger.Duplicates(1) != 2 || pinger.Duplicates(2) != 0 {
+		t.Errorf("Expected 2 duplicates of request 1 and none of 2, got %d and %d", pinger.Duplicates(1), pinger.Duplicates(2))
+	}
+}
+
+// TestEchoPingerWraparound sends a request whose 16-bit sequence number was
+// used and answered before, and expects its reply to be delivered rather than
+// counted as a duplicate of the earlier request.
+func TestEchoPingerWraparound(t *testing.T) {
+	pinger := &EchoPinger{waiting: make(map[int]chan time.Time), sequences: make(map[int]int), answered: make(map[int]bool), duplicates: make(map[int]int)}
+	pinger.register(1)
+	pinger.deliver(1, time.Now())
+	pinger.deliver(1, time.Now())
+
+	reply := pinger.register(65537)
+	pinger.deliver(1, time.Now())
+	if len(reply) != 1 {
+		t.Fatal("Expected the reply to request 65537 to be delivered")
+	}
+	pinger.deliver(1, time.Now())
+	if pinger.Duplicates(1) != 1 || pinger.Duplicates(65537) != 1 {
+		t.Errorf("Expected 1 duplicate of request 1 and 1 of request 65537, got %d and %d", pinger.Duplicates(1), pinger.Duplicates(65537))
+	}
+
+	reply = pinger.register(1) // a run starting over with the same pinger
+	pinger.deliver(1, time.Now())
+	if len(reply) != 1 || pinger.Duplicates(1) != 0 {
+		t.Errorf("Expected the reply to the new request 1 to be delivered without duplicates, got %d replies and %d duplicates", len(reply), pinger.Duplicates(1))
+	}
+}
+
+// TestEchoPingerLoopback pings the loopback address over the wrapping
+// sequence number, where ICMP sockets can be opened.
+func TestEchoPingerLoopback(t *testing.T) {
+	if err := ICMPAvailable(); err != nil {
+		t.Skip("ICMP is not available: " + err.Error())
+	}
+	pinger, err := NewEchoPinger("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pinger.Close()
+	for _, sequence := range []int{1, 65537, 1} {
+		if stat := pinger.Ping(sequence, 8, time.Second); !stat.Success {
+			t.Errorf("Expected a reply to request %d, got %+v", sequence, stat)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"regexp"
+	"time"
+)
+
+// maxExchangeResponse caps how much of a response is read while waiting for
+// the expected answer.
+const maxExchangeResponse = 64 * 1024
+
+// ExpectPattern compiles the telnet expectation, quoting it unless it is a
+// regular expression. It returns nil when nothing is expected.
+func (params InputParams) ExpectPattern() (*regexp.Regexp, error) {
+	if params.Expect == "" {
+		return nil, nil
+	}
+	if params.ExpectRegex {
+		return regexp.Compile(params.Expect)
+	}
+	return regexp.Compile(regexp.QuoteMeta(params.Expect))
+}
+
+// SendPayload returns the bytes telnet writes after connecting: the Send data,
+// Payload random bytes, or nil when the check stops at the handshake.
+func (params InputParams) SendPayload() ([]byte, error) {
+	if params.Send != "" {
+		return []byte(params.Send), nil
+	}
+	if !params.RandomPayload || params.Payload <= 0 {
+		return nil, nil
+	}
+	payload := make([]byte, params.Payload)
+	_, err := rand.Read(payload)
+	return payload, err
+}
+
+// Exchange writes payload to conn and reads until the response matches
+// expect, or until the first bytes arrive when expect is nil. It returns the
+// response read so far and the time between the write and the answer.
+func Exchange(conn net.Conn, payload []byte, expect *regexp.Regexp, timeout time.Duration) ([]byte, time.Duration, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
+	if len(payload) > 0 {
+		if _, err := conn.Write(payload); err != nil {
+			return nil, 0, err
+		}
+	}
+	response := make([]byte, 0, 512)
+	buffer := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buffer)
+		response = append(response, buffer[:n]...)
+		if n > 0 && (expect == nil || expect.Match(response)) {
+			return response, time.Since(start), nil
+		}
+		if err != nil {
+			if expect != nil && len(response) > 0 {
+				return response, time.Since(start), errors.New("response does not match '" + expect.String() + "': " + string(bytes.TrimSpace(Truncate(response, 80))))
+			}
+			return response, time.Since(start), err
+		}
+		if len(response) >= maxExchangeResponse {
+			return response, time.Since(start), errors.New("no match for '" + expect.String() + "' in the first 64KB of the response")
+		}
+	}
+}
+
+// Truncate returns at most size bytes of data.
+func Truncate(data []byte, size int) []byte {
+	if len(data) > size {
+		return data[:size]
+	}
+	return data
+}
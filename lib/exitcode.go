@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Process exit codes shared by every command, so that scripts can branch on
+// the outcome of a probe without parsing its output.
+const (
+	ExitSuccess = 0 // every probe succeeded, or enough of them for --success-threshold
+	ExitPartial = 1 // some probes failed
+	ExitFailure = 2 // every probe failed
+	ExitDNS     = 3 // the target could not be resolved
+	ExitUsage   = 4 // invalid arguments or flags
+	ExitExposed = 5 // nmap diff or --baseline found newly opened ports
+)
+
+// ParseSuccessThreshold reads a --success-threshold value such as "80%" or
+// "80" into a percentage between 0 and 100.
+func ParseSuccessThreshold(value string) (float64, error) {
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "%")), 64)
+	if err != nil || threshold < 0 || threshold > 100 {
+		return 0, fmt.Errorf("invalid success threshold '%s', expected a percentage between 0%% and 100%%", value)
+	}
+	return threshold, nil
+}
+
+// ExitCode classifies the result of a probe. A run whose success rate reaches
+// threshold (a percentage) counts as successful even when some probes failed.
+// Nmap scans succeed once the host was scanned, closed ports are not failures.
+func ExitCode(output JSONOutput, threshold float64) int {
+	summary := Summarize(output)
+	if output.DNSLookup.Hostname != "" && !output.DNSLookup.Success && summary.Succeeded == 0 {
+		return ExitDNS
+	}
+	if output.ModuleName == "nmap" {
+		if summary.Sent == 0 && output.Error != "" {
+			return ExitFailure
+		}
+		return ExitSuccess
+	}
+	switch {
+	case summary.Sent == 0:
+		return ExitFailure
+	case summary.Succeeded == summary.Sent, summary.SuccessRate() >= threshold:
+		return ExitSuccess
+	case summary.Succeeded == 0:
+		return ExitFailure
+	}
+	return ExitPartial
+}
+
+// CombineExitCodes merges the exit codes of several probes run by one command.
+// Identical codes are kept, a usage error wins, and any mix that includes a
+// success or a partial failure is a partial failure.
+func CombineExitCodes(codes ...int) int {
+	if len(codes) == 0 {
+		return ExitSuccess
+	}
+	combined := codes[0]
+	for _, code := range codes[1:] {
+		switch {
+		case code == combined:
+		case code == ExitUsage || combined == ExitUsage:
+			combined = ExitUsage
+		case code == ExitSuccess || combined == ExitSuccess || code == ExitPartial || combined == ExitPartial:
+			combined = ExitPartial
+		default:
+			combined = ExitFailure
+		}
+	}
+	return combined
+}
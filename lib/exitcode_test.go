@@ -0,0 +1,69 @@
+package lib
+
+import "testing"
+
+// TestExitCode checks the classification of probe results into exit codes.
+func TestExitCode(t *testing.T) {
+	resolved := DNSLookup{Hostname: "example.com", Success: true}
+	telnet := func(results ...bool) JSONOutput {
+		stats := make([]TelnetStats, 0)
+		for _, success := range results {
+			stats = append(stats, TelnetStats{Success: success, TimeTaken: 1000})
+		}
+		return JSONOutput{ModuleName: "telnet", DNSLookup: resolved, Stats: stats}
+	}
+	cases := []struct {
+		name      string
+		output    JSONOutput
+		threshold float64
+		expected  int
+	}{
+		{"all succeeded", telnet(true, true), 100, ExitSuccess},
+		{"partial", telnet(true, false), 100, ExitPartial},
+		{"partial above threshold", telnet(true, true, true, true, false), 80, ExitSuccess},
+		{"partial below threshold", telnet(true, true, true, false, false), 80, ExitPartial},
+		{"all failed", telnet(false, false), 100, ExitFailure},
+		{"nothing sent", JSONOutput{ModuleName: "web", DNSLookup: resolved}, 100, ExitFailure},
+		{"dns failure", JSONOutput{ModuleName: "telnet", DNSLookup: DNSLookup{Hostname: "nowhere.invalid"}, Stats: []TelnetStats{}}, 100, ExitDNS},
+		{"nmap with closed ports", JSONOutput{ModuleName: "nmap", DNSLookup: resolved, Stats: []NmapStats{{Port: 22}, {Port: 80, Success: true}}}, 100, ExitSuccess},
+	}
+	for _, c := range cases {
+		if code := ExitCode(c.output, c.threshold); code != c.expected {
+			t.Errorf("%s: expected exit code %d, got %d", c.name, c.expected, code)
+		}
+	}
+}
+
+// TestCombineExitCodes checks how the exit codes of several probes are merged.
+func TestCombineExitCodes(t *testing.T) {
+	cases := []struct {
+		codes    []int
+		expected int
+	}{
+		{[]int{}, ExitSuccess},
+		{[]int{ExitSuccess, ExitSuccess}, ExitSuccess},
+		{[]int{ExitSuccess, ExitFailure}, ExitPartial},
+		{[]int{ExitFailure, ExitDNS}, ExitFailure},
+		{[]int{ExitDNS, ExitDNS}, ExitDNS},
+		{[]int{ExitSuccess, ExitUsage}, ExitUsage},
+	}
+	for _, c := range cases {
+		if code := CombineExitCodes(c.codes...); code != c.expected {
+			t.Errorf("CombineExitCodes(%v): expected %d, got %d", c.codes, c.expected, code)
+		}
+	}
+}
+
+// TestParseSuccessThreshold checks the accepted --success-threshold forms.
+func TestParseSuccessThreshold(t *testing.T) {
+	for value, expected := range map[string]float64{"80%": 80, "80": 80, "99.5%": 99.5, "0%": 0} {
+		if threshold, err := ParseSuccessThreshold(value); err != nil || threshold != expected {
+			t.Errorf("ParseSuccessThreshold(%q) = %v, %v", value, threshold, err)
+		}
+	}
+	for _, value := range []string{"", "abc", "120%", "-1"} {
+		if _, err := ParseSuccessThreshold(value); err == nil {
+			t.Errorf("ParseSuccessThreshold(%q) should fail", value)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// AgentServer exposes the probes over HTTP so that a remote coordinator can
+// ask "can this network reach X?". Every probe endpoint accepts a body shaped
+// like lib.InputParams and answers with the lib.JSONOutput of the probe.
+type AgentServer struct {
+	Token   string // bearer token required on every probe request when set
+	Verbose bool   // log every probe request to stdout
+	slots   chan struct{}
+}
+
+func NewAgentServer(token string, maxconcurrent int, verbose bool) *AgentServer {
+	if maxconcurrent < 1 {
+		maxconcurrent = 1
+	}
+	return &AgentServer{Token: token, Verbose: verbose, slots: make(chan struct{}, maxconcurrent)}
+}
+
+// agentModules are the probes the agent API runs.
+var agentModules = []string{"telnet", "ping", "web", "nmap", "trace", "mtu"}
+
+// Handler returns the routes of the agent API:
+//
+//	GET  /v1/health
+//	POST /v1/probes/{telnet,ping,web,nmap,trace,mtu}
+func (agent *AgentServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/health", func(w http.ResponseWriter, r *http.Request) {
+		writeAgentJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST /v1/probes/{module}", agent.probe)
+	return mux
+}
+
+func (agent *AgentServer) probe(w http.ResponseWriter, r *http.Request) {
+	if agent.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(agent.Token)) != 1 {
+			writeAgentJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid bearer token"})
+			return
+		}
+	}
+	module := r.PathValue("module")
+	if !slices.Contains(agentModules, module) {
+		writeAgentJSON(w, http.StatusNotFound, map[string]string{"error": "unknown probe '" + module + "'"})
+		return
+	}
+	var params lib.InputParams
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params); err != nil {
+		writeAgentJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid input params: " + err.Error()})
+		return
+	}
+	params.Mode = module
+	params.Output, params.OutputDir = "", "" // never write files on the agent's disk for a remote caller
+	// a request body named @path would be read from the agent's disk
+	if params.HasBodyFiles() {
+		writeAgentJSON(w, http.StatusBadRequest, map[string]string{"error": "request bodies cannot be read from files on the agent"})
+		return
+	}
+	if err := params.Normalize(); err != nil {
+		writeAgentJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	select { // wait for a free slot unless the caller gives up first
+	case agent.slots <- struct{}{}:
+		defer func() { <-agent.slots }()
+	case <-r.Context().Done():
+		return
+	}
+	if agent.Verbose {
+		fmt.Println(lib.LogWithTimestamp(r.Method+" "+r.URL.Path+" from "+r.RemoteAddr+": "+params.Mode+" "+params.Target(), false))
+	}
+	writeAgentJSON(w, http.StatusOK, Probe(r.Context(), params))
+}
+
+func writeAgentJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// AgentHandler serves the agent API on listen until the server fails or ctx is
+// cancelled, in which case the probes in flight are answered before it returns.
+func AgentHandler(ctx context.Context, listen string, token string, maxconcurrent int, jsonoutput *bool) error {
+	agent := NewAgentServer(token, maxconcurrent, !*jsonoutput)
+	if !*jsonoutput {
+		auth := "without authentication"
+		if token != "" {
+			auth = "with bearer token authentication"
+		}
+		fmt.Println(lib.LogWithTimestamp(fmt.Sprintf("Agent listening on %s %s, running at most %d probes at a time", listen, auth, maxconcurrent), false))
+	}
+	// probes can take long to answer, but slow clients must not hold the
+	// connections of a network facing agent open
+	server := &http.Server{Addr: listen, Handler: agent.Handler(), ReadHeaderTimeout: 10 * time.Second, ReadTimeout: time.Minute, IdleTimeout: 2 * time.Minute}
+	go func() {
+		<-ctx.Done()
+		// stop accepting requests and let the probes in flight answer
+		server.Shutdown(context.WithoutCancel(ctx))
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TestAgentServer tests bearer token auth and a telnet probe through the agent API.
+func TestAgentServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	server := httptest.NewServer(NewAgentServer("s3cr3t", 2, false).Handler())
+	defer server.Close()
+
+	body := `{"host":"127.0.0.1","from_port":` + strconv.Itoa(port) + `,"count":2}`
+	post := func(path, token string) *http.Response {
+		request, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := post("/v1/probes/telnet", "wrong"); response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", response.StatusCode)
+	}
+	if response := post("/v1/probes/traceroute", "s3cr3t"); response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown probe, got %d", response.StatusCode)
+	}
+	body = `{"url":"http://127.0.0.1:` + strconv.Itoa(port) + `","method":"POST","data":"@/etc/hostname"}`
+	if response := post("/v1/probes/web", "s3cr3t"); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a request body read from the agent's disk, got %d", response.StatusCode)
+	}
+	body = `{"host":"127.0.0.1","from_port":` + strconv.Itoa(port) + `,"count":2}`
+
+	response := post("/v1/probes/telnet", "s3cr3t")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", response.StatusCode)
+	}
+	var output lib.JSONOutput
+	if err := json.NewDecoder(response.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	summary := lib.Summarize(output)
+	if output.ModuleName != "telnet" || summary.Sent != 2 || summary.Succeeded != 2 {
+		t.Errorf("Expected 2 successful telnet probes, got %+v", output)
+	}
+}
+
+// TestAgentModules runs the probes that need no listener through the agent
+// API, checking that each answers with its own module and stats.
+func TestAgentModules(t *testing.T) {
+	server := httptest.NewServer(NewAgentServer("", 2, false).Handler())
+	defer server.Close()
+	for module, body := range map[string]string{
+		"trace": `{"host":"127.0.0.1","max_hops":2,"count":1,"timeout_s":1}`,
+		"mtu":   `{"host":"127.0.0.1","max_mtu":1500,"count":1,"timeout_s":1}`,
+	} {
+		response, err := http.Post(server.URL+"/v1/probes/"+module, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output lib.JSONOutput
+		err = json.NewDecoder(response.Body).Decode(&output)
+		response.Body.Close()
+		if err != nil || response.StatusCode != http.StatusOK || output.ModuleName != module {
+			t.Errorf("Expected the %s probe to run, got %d and %+v (%v)", module, response.StatusCode, output, err)
+		}
+	}
+}
+
+// TestFanOut dispatches one probe to several in-process agents on loopback,
+// one of which rejects the token, and checks the merged results.
+func TestFanOut(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	agents := make([]string, 0)
+	for _, token := range []string{"s3cr3t", "s3cr3t", "other"} {
+		server := httptest.NewServer(NewAgentServer(token, 1, false).Handler())
+		defer server.Close()
+		agents = append(agents, server.URL)
+	}
+
+	params := lib.InputParams{Mode: "telnet", Host: "127.0.0.1", FromPort: listener.Addr().(*net.TCPAddr).Port}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	jsonoutput := true
+	results := FanOutHandler(t.Context(), params, agents, "s3cr3t", &jsonoutput)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 vantage results, got %d", len(results))
+	}
+	for i, result := range results[:2] {
+		if result.Error != "" || lib.Summarize(result.Result).Succeeded != 1 {
+			t.Errorf("Vantage %d: expected a successful probe, got %+v", i, result)
+		}
+	}
+	if !strings.Contains(results[2].Error, "401") {
+		t.Errorf("Expected the third agent to reject the token, got %q", results[2].Error)
+	}
+}
+
+// TestFanOutRedacts checks that the credentials of a web probe reach the
+// agents but not the printed JSON output.
+func TestFanOutRedacts(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "admin" || password != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer target.Close()
+	agent := httptest.NewServer(NewAgentServer("", 1, false).Handler())
+	defer agent.Close()
+
+	params := lib.InputParams{Mode: "web", URL: target.URL, User: "admin:s3cr3t"}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	jsonoutput := true
+	results := FanOutHandler(t.Context(), params, []string{agent.URL}, "", &jsonoutput)
+	w.Close()
+	os.Stdout = stdout
+	printed, _ := io.ReadAll(r)
+
+	if stats, ok := results[0].Result.Stats.([]lib.WebStats); !ok || len(stats) != 1 || stats[0].StatusCode != http.StatusOK {
+		t.Errorf("Expected the agent to authenticate, got %+v", results[0])
+	}
+	if strings.Contains(string(printed), "s3cr3t") || !strings.Contains(string(printed), lib.RedactedValue) {
+		t.Errorf("Expected the password to be redacted from the output, got %s", printed)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// VantageResult is the outcome of one probe dispatched to a remote agent.
+type VantageResult struct {
+	Agent  string         `json:"agent"`
+	Error  string         `json:"error"`
+	Result lib.JSONOutput `json:"result"`
+}
+
+// AgentURL turns an agent given as name, host:port or URL into the base URL of
+// its API. Agents without a port are assumed to listen on the default :8080.
+func AgentURL(agent string) string {
+	if strings.HasPrefix(agent, "http://") || strings.HasPrefix(agent, "https://") {
+		return strings.TrimSuffix(agent, "/")
+	}
+	if !strings.Contains(agent, ":") || strings.HasSuffix(agent, "]") {
+		agent += ":8080"
+	}
+	return "http://" + agent
+}
+
+// ProbeAgent asks the agent to run the probe described by params and returns
+// the JSON output it answered with.
+func ProbeAgent(ctx context.Context, agent string, token string, params lib.InputParams) (lib.JSONOutput, error) {
+	var output lib.JSONOutput
+	module := params.Mode
+	if module == "icmp" {
+		module = "ping"
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return output, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, AgentURL(agent)+"/v1/probes/"+module, bytes.NewReader(body))
+	if err != nil {
+		return output, err
+	}
+	request.Header.Set("content-type", "application/json")
+	if token != "" {
+		request.Header.Set("authorization", "Bearer "+token)
+	}
+	// The agent only answers once the whole probe is done, which it bounds with
+	// the timeouts of params however long the ports, hops or delays make it, so
+	// the request has no deadline of its own and only ends with ctx. The TCP
+	// keep-alives of the default transport still notice an agent that is gone.
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return output, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(response.Body)
+		if json.Unmarshal(data, &failure) != nil || failure.Error == "" {
+			failure.Error = strings.TrimSpace(string(data))
+		}
+		return output, fmt.Errorf("agent answered %s: %s", response.Status, failure.Error)
+	}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return output, err
+}
+
+// FanOutHandler dispatches the same probe to every agent concurrently and
+// prints their results side by side, one row per vantage point.
+func FanOutHandler(ctx context.Context, params lib.InputParams, agents []string, token string, jsonoutput *bool) []VantageResult {
+	results := make([]VantageResult, len(agents))
+	var WG sync.WaitGroup
+	for i, agent := range agents {
+		WG.Add(1)
+		go func(i int, agent string) {
+			defer WG.Done()
+			output, err := ProbeAgent(ctx, agent, token, params)
+			results[i] = VantageResult{Agent: agent, Result: output}
+			if err != nil {
+				results[i].Error = err.Error()
+			} else if output.Error != "" {
+				results[i].Error = output.Error
+			}
+		}(i, agent)
+	}
+	WG.Wait()
+
+	if *jsonoutput {
+		JS, _ := json.MarshalIndent(struct {
+			SchemaVersion int             `json:"schema_version"`
+			InputParams   lib.InputParams `json:"input_params"`
+			Vantages      []VantageResult `json:"vantages"`
+		}{lib.SchemaVersion, params.Redacted(), results}, "", "  ")
+		fmt.Println(string(JS))
+		return results
+	}
+	title := params.Mode + " " + params.Target() + " from " + strconv.Itoa(len(agents)) + " vantage points"
+	fmt.Println("\n" + strings.Repeat("=", 20) + " " + title + " " + strings.Repeat("=", 20))
+	fmt.Printf("%-24s %-8s %6s %9s %12s %12s %12s  %s\n", "Vantage", "DNS", "Sent", "Success", "Min", "Avg", "Max", "Error")
+	for _, result := range results {
+		dns := "failed"
+		if result.Result.DNSLookup.Success {
+			dns = "ok"
+		}
+		side := lib.NewReplaySide(result.Result)
+		latency := func(value int64) string {
+			if len(lib.Summarize(result.Result).Latencies) == 0 {
+				return "-"
+			}
+			return (time.Duration(value) * time.Microsecond).String()
+		}
+		fmt.Printf("%-24s %-8s %6d %8.1f%% %12s %12s %12s  %s\n", result.Agent, dns, side.Sent, side.SuccessRate, latency(side.MinLatency), latency(side.AvgLatency), latency(side.MaxLatency), result.Error)
+	}
+	return results
+}
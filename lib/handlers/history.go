@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// HistoryHandler prints the daily success and latency trend of every module
+// that probed target in the last days, read from the history file at path.
+func HistoryHandler(path string, target string, module string, days int, jsonoutput *bool) {
+	since := time.Now().AddDate(0, 0, -days)
+	records, err := lib.ReadHistory(path, target, module, since)
+	if err != nil {
+		fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+		os.Exit(1)
+	}
+	groups := make(map[string][]lib.HistoryRecord)
+	keys := make([]string, 0)
+	for _, record := range records {
+		key := record.ModuleName + " " + record.Target
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], record)
+	}
+	slices.Sort(keys)
+
+	if *jsonoutput {
+		trends := make(map[string][]lib.HistoryDay)
+		for _, key := range keys {
+			trends[key] = lib.Trend(groups[key])
+		}
+		JS, _ := json.MarshalIndent(struct {
+			SchemaVersion int                         `json:"schema_version"`
+			Trends        map[string][]lib.HistoryDay `json:"trends"` // keyed by module and target
+		}{lib.SchemaVersion, trends}, "", "  ")
+		fmt.Println(string(JS))
+		return
+	}
+	if len(keys) == 0 {
+		fmt.Println(lib.LogWithTimestamp("No history recorded for '"+target+"' in the last "+strconv.Itoa(days)+" days in "+path, false))
+		return
+	}
+	for _, key := range keys {
+		trend := lib.Trend(groups[key])
+		fmt.Println("\n" + strings.Repeat("=", 10) + " " + key + " over the last " + strconv.Itoa(days) + " days (" + strconv.Itoa(len(groups[key])) + " runs) " + strings.Repeat("=", 10))
+		fmt.Printf("%-12s %6s %10s %14s\n", "Day", "Runs", "Success", "Avg latency")
+		latencies, successes := make([]float64, 0), make([]float64, 0)
+		for _, day := range trend {
+			if day.Runs == 0 {
+				fmt.Printf("%-12s %6s %10s %14s\n", day.Day, "-", "-", "-")
+				latencies, successes = append(latencies, -1), append(successes, -1)
+				continue
+			}
+			latency := "-"
+			if day.Succeeded > 0 {
+				latency = (time.Duration(day.AvgLatency) * time.Microsecond).Round(10 * time.Microsecond).String()
+				latencies = append(latencies, float64(day.AvgLatency))
+			} else {
+				latencies = append(latencies, -1)
+			}
+			successes = append(successes, day.SuccessRate)
+			fmt.Printf("%-12s %6d %9.1f%% %14s\n", day.Day, day.Runs, day.SuccessRate, latency)
+		}
+		fmt.Println("Latency  " + lib.Sparkline(latencies))
+		fmt.Println("Success  " + lib.SparklineBetween(successes, 0, 100))
+	}
+}
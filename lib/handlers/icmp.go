@@ -1,20 +1,22 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"os"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/dmartsapp/go-ping/netutils"
 	"github.com/dmartsapp/shint/lib"
 )
 
-func HandleICMP(host string, jsonoutput *bool, iterations int, delay int, throttle *bool, timeout int, payload_size int) {
-
-	output := lib.JSONOutput{}
-	output.InputParams = lib.InputParams{
+func HandleICMP(ctx context.Context, host string, jsonoutput *bool, iterations int, delay int, throttle *bool, timeout int, payload_size int) lib.JSONOutput {
+	params := lib.InputParams{
 		Mode:     "icmp",
 		Host:     host,
 		FromPort: int(7),
@@ -26,70 +28,237 @@ func HandleICMP(host string, jsonoutput *bool, iterations int, delay int, thrott
 		Payload:  payload_size,
 		Throttle: *throttle,
 	}
-	output.ModuleName = "icmp"
+	return PingHandler(ctx, params, jsonoutput)
+}
+
+// PingHandler pings params.Host with ICMP echo requests, or with TCP
+// handshakes to params.FromPort when params.Protocol is tcp, and prints the
+// replies and the ping statistics.
+func PingHandler(ctx context.Context, params lib.InputParams, jsonoutput *bool) lib.JSONOutput {
+	output := runICMP(ctx, params, !*jsonoutput)
+	if *jsonoutput {
+		JS, jsonErr := json.MarshalIndent(output, "", "  ")
+		if jsonErr != nil {
+			fmt.Println(lib.LogWithTimestamp(jsonErr.Error(), true))
+			os.Exit(1)
+		}
+		fmt.Println(string(JS))
+		return output
+	}
+	if output.Error != "" {
+		fmt.Println(lib.LogWithTimestamp(output.Error, true))
+	}
+	if output.DNSLookup.Success { // without addresses there are no statistics to show
+		printInterrupted(output)
+		stats, _ := output.Stats.([]lib.ICMPStats)
+		sent, received := len(stats), 0
+		times := make([]float64, 0)
+		for _, stat := range stats {
+			if stat.Success {
+				received++
+				times = append(times, float64(stat.TimeTaken)/1000) // µs to fractional ms
+			}
+		}
+		success := 0
+		if sent > 0 {
+			success = received * 100 / sent
+		}
+		min, avg, max, stddev := minAvgMaxStdDev(times)
+		total := time.Duration(output.TotalTimeTaken) * time.Microsecond
+		fmt.Println("========================================= Ping stats ============================================")
+		if output.InputParams.Protocol == lib.PingTCP {
+			fmt.Printf("Method: TCP handshakes to port %d\n", output.InputParams.FromPort)
+		}
+		fmt.Printf("Packets sent: %d, Packets received: %d, Packets lost: %d, Ping success: %d%% \n", sent, received, sent-received, success)
+		fmt.Printf("Total time: %v, Resolve time: %v\n", total, time.Duration(output.DNSLookup.TimeTaken)*time.Microsecond)
+		fmt.Printf("Min time: %.3fms, Max time: %.3fms, Avg time: %.3fms, Std dev: %.3fms, Total time: %v\n", min, max, avg, stddev, total)
+		printPingAnalysis(output.Analysis)
+	}
+	return output
+}
+
+// runICMP pings every address the host resolves to, params.Count times, and
+// collects the results. Every address gets one socket for the whole run, so
+// that duplicated replies are noticed, and a cancelled ctx stops the run
+// between rounds while the requests already on the wire are still waited
+// for. Replies are streamed only when verbose is set.
+//
+// With params.FallbackPort set, the run switches to TCP handshakes with that
+// port when no ICMP socket can be opened or when the first round of echo
+// requests got no reply at all, e.g. because the target drops ICMP. The
+// params of the output then record the tcp protocol and the port.
+func runICMP(ctx context.Context, params lib.InputParams, verbose bool) lib.JSONOutput {
+	output := lib.JSONOutput{InputParams: params, ModuleName: "icmp"}
 	start := time.Now()
-	pinger, err := netutils.NewPinger(host)
+	output.StartTime = start.UnixMicro()
+	dnsctx, cancel := context.WithTimeout(ctx, time.Duration(params.Timeout)*time.Second)
+	ips, err := lib.ResolveNameToIPs(dnsctx, params.Host)
+	cancel()
+	output.DNSLookup = lib.DNSLookup{
+		Hostname:          params.Host,
+		Success:           err == nil,
+		ResolvedAddresses: lib.ConvertIPToStringSlice(ips),
+		TimeTaken:         time.Since(start).Microseconds(),
+	}
 	if err != nil {
-		fmt.Println(lib.LogWithTimestamp(err.Error(), true))
-		os.Exit(1)
+		output.Error = "Unable to resolve the name for '" + params.Host + "'"
+		output.DNSLookup.Error = err.Error()
+		output.Cancelled = ctx.Err() == context.Canceled
+		output.EndTime = time.Now().UnixMicro()
+		output.TotalTimeTaken = output.EndTime - output.StartTime
+		return output
 	}
 
-	wg := sync.WaitGroup{}
+	fallback := func(reason string) {
+		if verbose {
+			fmt.Println(lib.LogWithTimestamp(reason+", falling back to TCP handshakes with port "+strconv.Itoa(params.FallbackPort), false))
+		}
+		params.Protocol, params.FromPort, params.ToPort = lib.PingTCP, params.FallbackPort, params.FallbackPort
+		output.InputParams = params
+	}
+	if params.Protocol != lib.PingTCP && params.FallbackPort > 0 {
+		if err := lib.ICMPAvailable(); err != nil {
+			fallback("ICMP is not available (" + err.Error() + ")")
+		}
+	}
 
-	if !*jsonoutput {
-		wg.Add(1)
-		go func(pinger *netutils.Pinger, wg *sync.WaitGroup) {
-			defer wg.Done()
-			for log := range pinger.StreamLog() {
-				fmt.Println(lib.LogWithTimestamp(log, false))
+	pingers := make(map[string]*lib.EchoPinger) // one socket per address for the whole run
+	pingerErrors := make(map[string]error)
+	if params.Protocol != lib.PingTCP {
+		for _, ip := range ips {
+			if pingers[ip.String()], err = lib.NewEchoPinger(ip.String()); err != nil {
+				delete(pingers, ip.String())
+				pingerErrors[ip.String()] = err
 			}
-		}(pinger, &wg)
+		}
 	}
+	defer func() {
+		for _, pinger := range pingers {
+			pinger.Close()
+		}
+	}()
 
-	pinger.
-		SetPingCount(iterations).
-		SetParallelPing(true).
-		SetPayloadSizeInBytes(payload_size).
-		SetPingDelayInMS(delay).
-		SetRandomizedPingDelay(*throttle)
-	err = pinger.PingAll()
-	if err != nil {
-		fmt.Println(lib.LogWithTimestamp(err.Error(), true))
-		os.Exit(1)
+	var WG sync.WaitGroup
+	var MUTEX sync.Mutex
+	stats := make([]lib.ICMPStats, 0)
+	for sequence := 1; sequence <= params.Count; sequence++ {
+		if sequence > 1 {
+			wait := time.Duration(params.Delay) * time.Millisecond
+			if params.Throttle {
+				if wait, err = lib.ThrottleDelay(); err != nil {
+					output.Error = err.Error()
+					break
+				}
+			}
+			lib.Sleep(ctx, wait)
+		}
+		if ctx.Err() != nil { // interrupted, stop sending and wait for the replies in flight
+			break
+		}
+		for _, ip := range ips {
+			WG.Add(1)
+			go func(address string, sequence int) {
+				defer WG.Done()
+				var stat lib.ICMPStats
+				switch pinger := pingers[address]; {
+				case params.Protocol == lib.PingTCP:
+					stat = lib.TCPPing(address, params.FromPort, sequence, time.Duration(params.Timeout)*time.Second)
+				case pinger != nil:
+					stat = pinger.Ping(sequence, params.Payload, time.Duration(params.Timeout)*time.Second)
+				default:
+					stat = lib.ICMPStats{Address: address, Sequence: sequence, PayloadSize: params.Payload, Method: lib.PingICMP, Error: pingerErrors[address].Error()}
+				}
+				if verbose {
+					printPingReply(stat)
+				}
+				MUTEX.Lock()
+				stats = append(stats, stat)
+				MUTEX.Unlock()
+			}(ip.String(), sequence)
+		}
+		if sequence == 1 && params.Protocol != lib.PingTCP && params.FallbackPort > 0 {
+			WG.Wait()
+			if !slices.ContainsFunc(stats, func(stat lib.ICMPStats) bool { return stat.Success }) && ctx.Err() == nil {
+				fallback("No reply to ICMP echo requests")
+				stats, sequence = stats[:0], 0 // start over with TCP
+			}
+		}
+	}
+	WG.Wait()
+	for i, stat := range stats {
+		if pinger := pingers[stat.Address]; pinger != nil && stat.Method == lib.PingICMP {
+			stats[i].Duplicates = pinger.Duplicates(stat.Sequence)
+		}
 	}
 
-	wg.Wait()
-	pinger.MeasureStats()
-	// pinger.MeasureStats()
-	if !*jsonoutput {
-		fmt.Println("========================================= Ping stats ============================================")
-		fmt.Printf("Packets sent: %d, Packets received: %d, Packets lost: %d, Ping success: %d%% \n", pinger.Count*len(pinger.Destination), (pinger.Count*len(pinger.Destination) - pinger.Stats.Loss), pinger.Stats.Loss, ((pinger.Count*len(pinger.Destination) - pinger.Stats.Loss) * 100 / (pinger.Count * len(pinger.Destination))))
-		fmt.Printf("Total time: %v, Resolve time: %v\n", pinger.Stats.TotalTime, pinger.Stats.ResolveTime)
-		fmt.Printf("Min time: %dms, Max time: %dms, Avg time: %.3fms, Std dev: %.3f, Total time: %v\n", pinger.Stats.Min, pinger.Stats.Max, pinger.Stats.Avg, pinger.Stats.StdDev, pinger.Stats.TotalTime)
-	} else {
-		output.DNSLookup = lib.DNSLookup{
-			Hostname:          host,
-			Success:           true,
-			ResolvedAddresses: lib.ConvertIPToStringSlice(pinger.Destination),
-			TimeTaken:         pinger.Stats.ResolveTime.Microseconds(),
+	output.Stats = stats
+	output.Analysis = lib.AnalyzePing(stats)
+	output.Cancelled = ctx.Err() == context.Canceled
+	output.EndTime = time.Now().UnixMicro()
+	output.TotalTimeTaken = output.EndTime - output.StartTime
+	return output
+}
+
+// printPingAnalysis shows the jitter, reordering and loss bursts of every
+// address, and the per-second timeline when the run lasted several seconds.
+func printPingAnalysis(analyses []lib.PingAnalysis) {
+	for _, analysis := range analyses {
+		prefix := ""
+		if len(analyses) > 1 {
+			prefix = analysis.Address + ": "
 		}
-		output.StartTime = start.UnixMicro()
-		output.EndTime = time.Now().UnixMicro()
-		output.TotalTimeTaken = output.EndTime - output.StartTime
-		output.Stats = make([]lib.ICMPStats, 0)
-		for _, pckts := range pinger.Stats.Packets {
-			stat := lib.ICMPStats{}
-			stat.Address = pckts.Destination.String()
-			stat.Success = !pckts.ErrorEncountered
-			stat.Sequence = pckts.Sequence
-			stat.SentTime = pckts.SentDateTimeUNIX
-			stat.RecvTime = pckts.ReceiveDateTimeUNIX
-			stat.TimeTaken = stat.RecvTime - stat.SentTime
-			output.Stats = append(output.Stats.([]lib.ICMPStats), stat)
+		fmt.Printf("%sJitter: %.3fms, Out of order: %d, Duplicates: %d, Loss bursts: %d, Longest loss burst: %d\n", prefix, float64(analysis.Jitter)/1000, analysis.OutOfOrder, analysis.Duplicates, analysis.LossBursts, analysis.LongestLossBurst)
+		if len(analysis.Timeline) < 2 {
+			continue
 		}
+		fmt.Println(prefix + "Timeline:")
+		for _, second := range analysis.Timeline {
+			fmt.Printf("  %4ds  sent: %d, received: %d, loss: %.0f%%, avg: %.3fms\n", second.Second, second.Sent, second.Received, second.Loss, float64(second.Avg)/1000)
+		}
+	}
+}
 
-		JS, _ := json.MarshalIndent(output, "", "  ")
-		fmt.Println(string(JS))
+// millis formats a duration in microseconds as fractional milliseconds, the
+// way iputils ping shows round trip times.
+func millis(micros int64) string {
+	return strconv.FormatFloat(float64(micros)/1000, 'f', 3, 64) + "ms"
+}
 
+// printPingReply shows the outcome of one echo request or TCP handshake.
+func printPingReply(stat lib.ICMPStats) {
+	request := "request #" + strconv.Itoa(stat.Sequence)
+	if stat.Method == lib.PingTCP {
+		target := net.JoinHostPort(stat.Address, strconv.Itoa(stat.Port))
+		if stat.Success {
+			fmt.Println(lib.LogWithTimestamp("Connected to "+target+" for "+request+" in "+millis(stat.TimeTaken), false))
+		} else {
+			fmt.Println(lib.LogWithTimestamp("Error encountered for "+request+" to "+target+": "+stat.Error, false))
+		}
+		return
+	}
+	if stat.Success {
+		fmt.Println(lib.LogWithTimestamp("Received response for "+request+" from "+stat.Address+" with "+strconv.Itoa(stat.PayloadSize)+" bytes of data in "+millis(stat.TimeTaken), false))
+	} else {
+		fmt.Println(lib.LogWithTimestamp("Error encountered for "+request+" to "+stat.Address+" with "+strconv.Itoa(stat.PayloadSize)+" bytes of data", false))
+	}
+}
+
+// minAvgMaxStdDev returns the minimum, average, maximum and population
+// standard deviation of values, all zero when values is empty.
+func minAvgMaxStdDev(values []float64) (float64, float64, float64, float64) {
+	if len(values) == 0 {
+		return 0, 0, 0, 0
+	}
+	min, max, sum := values[0], values[0], 0.0
+	for _, value := range values {
+		min = math.Min(min, value)
+		max = math.Max(max, value)
+		sum += value
+	}
+	avg := sum / float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - avg) * (value - avg)
 	}
+	return min, avg, max, math.Sqrt(variance / float64(len(values)))
 }
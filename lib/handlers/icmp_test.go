@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TestTCPPing pings a local listener with TCP handshakes and checks that the
+// results are reported like ICMP replies, marked with the method.
+func TestTCPPing(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	params := lib.InputParams{Mode: "ping", Host: "127.0.0.1", Protocol: "tcp", FromPort: port, Count: 3, Delay: 10}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	output := Probe(context.Background(), params)
+	stats, _ := output.Stats.([]lib.ICMPStats)
+	if len(stats) != 3 {
+		t.Fatalf("Expected 3 handshakes, got %+v", output)
+	}
+	for _, stat := range stats {
+		if !stat.Success || stat.Method != lib.PingTCP || stat.Port != port {
+			t.Errorf("Unexpected TCP ping result %+v", stat)
+		}
+	}
+	if summary := lib.Summarize(output); summary.SuccessRate() != 100 {
+		t.Errorf("Expected 100%% success, got %v", summary.SuccessRate())
+	}
+}
+
+// TestPingInterrupted cancels a TCP ping during its second handshake and
+// checks that the replies in flight are waited for and summarized.
+func TestPingInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	port := listenAndCancel(t, 2, cancel, func(conn net.Conn) { conn.Close() })
+	params := lib.InputParams{Mode: "ping", Host: "127.0.0.1", Protocol: "tcp", FromPort: port, Count: 50, Delay: 10}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	output := runICMP(ctx, params, false)
+	stats, _ := output.Stats.([]lib.ICMPStats)
+	if !output.Cancelled || len(stats) < 2 || len(stats) == params.Count {
+		t.Fatalf("Expected a cancelled run with part of the %d handshakes, got %d in %+v", params.Count, len(stats), output)
+	}
+	for _, stat := range stats {
+		if !stat.Success {
+			t.Errorf("Expected the handshakes in flight to complete, got %+v", stat)
+		}
+	}
+	if summary := lib.Summarize(output); summary.Sent != len(stats) || summary.SuccessRate() != 100 {
+		t.Errorf("Expected the summary of the %d handshakes sent, got %+v", len(stats), summary)
+	}
+}
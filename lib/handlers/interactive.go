@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TelnetInteractiveHandler connects to host:port and pipes stdin to the
+// connection and the connection to stdout until either side closes or ctx is
+// cancelled, like netcat. With udp, every read from stdin is sent as one
+// datagram and the session ends --timeout seconds after stdin is closed.
+// With crlf, line feeds read from stdin are sent as CR LF. With hexdump, the
+// bytes in both directions are shown as a hex dump instead of being copied.
+// With negotiate, Telnet commands from the server are answered and stripped
+// from the output. Status lines go to stderr so that stdout only carries
+// what the server sent.
+func TelnetInteractiveHandler(ctx context.Context, host string, port int, timeout int, udp bool, crlf bool, hexdump bool, negotiate bool) error {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := net.Dialer{Timeout: time.Duration(timeout) * time.Second}
+	istart := time.Now()
+	protocol := lib.Protocol
+	if udp {
+		protocol = "udp"
+	}
+	conn, err := dialer.DialContext(ctx, protocol, address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, lib.LogWithTimestamp(err.Error(), true))
+		return err
+	}
+	fmt.Fprintln(os.Stderr, lib.LogWithTimestamp("Connected to "+conn.RemoteAddr().String()+" after "+time.Since(istart).String()+", press Ctrl-C or close stdin to quit", false))
+
+	var MUTEX sync.Mutex // serialises writes of stdin data and negotiation replies
+	codec := lib.TelnetCodec{}
+	write := func(data []byte) error {
+		MUTEX.Lock()
+		defer MUTEX.Unlock()
+		_, err := conn.Write(data)
+		return err
+	}
+	var sent, received atomic.Int64
+	done := make(chan error, 1)
+
+	go func() { // server to stdout
+		buffer := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(buffer)
+			if n > 0 {
+				received.Add(int64(n))
+				data := buffer[:n]
+				if negotiate {
+					var reply []byte
+					data, reply = codec.Decode(data)
+					if len(reply) > 0 {
+						_ = write(reply)
+					}
+				}
+				if hexdump && len(data) > 0 {
+					fmt.Fprintln(os.Stderr, lib.LogWithTimestamp("Received "+strconv.Itoa(len(data))+" bytes", false))
+					fmt.Print(hex.Dump(data))
+				} else {
+					os.Stdout.Write(data)
+				}
+			}
+			if err != nil {
+				var timeoutErr net.Error
+				if errors.Is(err, io.EOF) || (errors.As(err, &timeoutErr) && timeoutErr.Timeout()) {
+					err = nil
+				}
+				done <- err
+				return
+			}
+		}
+	}()
+
+	go func() { // stdin to server
+		buffer := make([]byte, 32*1024)
+		lastCR := false
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if n > 0 {
+				data := buffer[:n]
+				if crlf {
+					data, lastCR = lib.TranslateCRLF(data, lastCR)
+				}
+				if hexdump {
+					fmt.Fprintln(os.Stderr, lib.LogWithTimestamp("Sent "+strconv.Itoa(len(data))+" bytes", false))
+					fmt.Fprint(os.Stderr, hex.Dump(data))
+				}
+				if negotiate {
+					data = codec.Encode(data)
+				}
+				if werr := write(data); werr != nil {
+					return
+				}
+				sent.Add(int64(len(data)))
+			}
+			if err != nil { // stdin closed, let the server finish its answer
+				if tcp, ok := conn.(*net.TCPConn); ok {
+					_ = tcp.CloseWrite()
+				} else { // UDP has no end of conversation, wait for the last replies
+					_ = conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+				}
+				return
+			}
+		}
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+	}
+	conn.Close()
+	fmt.Fprintln(os.Stderr, lib.LogWithTimestamp("Connection to "+address+" closed after "+time.Since(istart).String()+", bytes sent: "+strconv.FormatInt(sent.Load(), 10)+", bytes received: "+strconv.FormatInt(received.Load(), 10), false))
+	return err
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// MonitorHandler probes the target described by params every interval seconds
+// until ctx is done, printing only the state changes reported by lib.Monitor.
+// In JSON mode every event is printed as a single line JSON object. Every round
+// is also handed to report, which main uses for --notify.
+func MonitorHandler(ctx context.Context, params lib.InputParams, interval int, window int, threshold int, jsonoutput *bool, report func(lib.JSONOutput)) {
+	monitor := lib.NewMonitor(window, time.Duration(threshold)*time.Millisecond)
+	if !*jsonoutput {
+		fmt.Println(lib.LogWithTimestamp("Monitoring "+params.Mode+" "+params.Target()+" every "+(time.Duration(interval)*time.Second).String()+", press Ctrl-C to stop", false))
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		output := Probe(ctx, params)
+		if ctx.Err() != nil || output.Cancelled { // a round cut short by Ctrl-C is no outage
+			return
+		}
+		if report != nil {
+			report(output)
+		}
+		for _, event := range monitor.Observe(time.Now(), output) {
+			if *jsonoutput {
+				JS, _ := json.Marshal(event)
+				fmt.Println(string(JS))
+			} else {
+				fmt.Println(lib.LogWithTimestamp(event.Message, event.Event == lib.MonitorEventDown))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TestMonitorHandlerInterrupted stops a monitor during its first round and
+// checks that the cut short round is neither reported nor seen as an outage.
+func TestMonitorHandlerInterrupted(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	params := lib.InputParams{Mode: "telnet", Host: "127.0.0.1", FromPort: listener.Addr().(*net.TCPAddr).Port}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	jsonoutput := true
+	reported := 0
+	MonitorHandler(ctx, params, 1, 5, 0, &jsonoutput, func(lib.JSONOutput) { reported++ })
+	if reported != 0 {
+		t.Errorf("Expected the interrupted round not to be reported, got %d reports", reported)
+	}
+}
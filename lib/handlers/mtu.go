@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// MTUHandler discovers the path MTU to params.Host and prints it together
+// with the smallest packet size that did not pass and why.
+func MTUHandler(ctx context.Context, params lib.InputParams, jsonoutput *bool) lib.JSONOutput {
+	output := runMTU(ctx, params, !*jsonoutput)
+	if *jsonoutput {
+		JS, jsonErr := json.MarshalIndent(output, "", "  ")
+		if jsonErr != nil {
+			fmt.Println(lib.LogWithTimestamp(jsonErr.Error(), true))
+			os.Exit(1)
+		}
+		fmt.Println(string(JS))
+		return output
+	}
+	if output.Error != "" {
+		fmt.Println(lib.LogWithTimestamp(output.Error, true))
+	}
+	printInterrupted(output)
+	if stats, ok := output.Stats.(lib.MTUStats); ok {
+		fmt.Println("========================================= MTU stats =============================================")
+		if stats.PathMTU > 0 {
+			fmt.Printf("Path MTU to %s: %d bytes (%d bytes of ICMP data), probes sent: %d\n", stats.Address, stats.PathMTU, stats.MaxPayload, len(stats.Probes))
+		}
+		switch {
+		case stats.FailedAt == 0 && stats.PathMTU > 0:
+			fmt.Printf("Packets up to the --max-mtu of %d bytes pass unfragmented\n", params.MaxMTU)
+		case stats.FailedBy == "":
+			fmt.Printf("Packets of %d bytes and more do not pass: %s, they are dropped without an ICMP error (black hole)\n", stats.FailedAt, stats.FailReason)
+		case stats.FailedBy == "local":
+			fmt.Printf("Packets of %d bytes and more do not pass: %s, the route of the local interface does not carry them\n", stats.FailedAt, stats.FailReason)
+		default:
+			fmt.Printf("Packets of %d bytes and more do not pass: %s at %s\n", stats.FailedAt, stats.FailReason, stats.FailedBy)
+		}
+	}
+	fmt.Println("Total time taken: " + (time.Duration(output.TotalTimeTaken) * time.Microsecond).String())
+	return output
+}
+
+// runMTU resolves params.Host and discovers the path MTU to its first IPv4
+// address, params.Count echo requests being sent for a size that gets no
+// answer before it is deemed too large.
+func runMTU(ctx context.Context, params lib.InputParams, verbose bool) lib.JSONOutput {
+	output := lib.JSONOutput{InputParams: params, ModuleName: "mtu"}
+	start := time.Now()
+	output.StartTime = start.UnixMicro()
+	finish := func() lib.JSONOutput {
+		output.Cancelled = ctx.Err() == context.Canceled
+		output.EndTime = time.Now().UnixMicro()
+		output.TotalTimeTaken = output.EndTime - output.StartTime
+		return output
+	}
+	dnsctx, cancel := context.WithTimeout(ctx, time.Duration(params.Timeout)*time.Second)
+	ips, err := lib.ResolveNameToIPs(dnsctx, params.Host)
+	cancel()
+	output.DNSLookup = lib.DNSLookup{
+		Hostname:          params.Host,
+		Success:           err == nil,
+		ResolvedAddresses: lib.ConvertIPToStringSlice(ips),
+		TimeTaken:         time.Since(start).Microseconds(),
+	}
+	if err != nil {
+		output.Error = "Unable to resolve the name for '" + params.Host + "'"
+		output.DNSLookup.Error = err.Error()
+		return finish()
+	}
+	var target net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			target = ip
+			break
+		}
+	}
+	if target == nil {
+		output.Error = params.Host + " has no IPv4 address to probe"
+		return finish()
+	}
+	if verbose {
+		fmt.Println(lib.LogWithTimestamp("Discovering the path MTU to "+params.Host+" ("+target.String()+") with unfragmented ICMP echo requests of up to "+strconv.Itoa(params.MaxMTU)+" bytes", false))
+	}
+	stats, err := lib.DiscoverMTU(ctx, target, lib.MTUOptions{
+		MaxMTU:   params.MaxMTU,
+		Attempts: params.Count,
+		Timeout:  time.Duration(params.Timeout) * time.Second,
+	})
+	if err != nil {
+		output.Error = err.Error()
+		return finish()
+	}
+	if verbose {
+		for _, probe := range stats.Probes {
+			message := strconv.Itoa(probe.Size) + " bytes: " + probe.Result
+			if probe.From != "" && probe.From != "local" && probe.From != stats.Address {
+				message += " from " + probe.From
+			}
+			if probe.NextHopMTU > 0 {
+				message += ", next-hop MTU " + strconv.Itoa(probe.NextHopMTU)
+			}
+			fmt.Println(lib.LogWithTimestamp(message, !probe.Success))
+		}
+	}
+	output.Stats = stats
+	return finish()
+}
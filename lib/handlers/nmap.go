@@ -2,10 +2,8 @@ package handlers
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/json"
 	"fmt"
-	"math/big"
 	"os"
 	"strconv"
 	"strings"
@@ -15,76 +13,125 @@ import (
 	"github.com/dmartsapp/shint/lib"
 )
 
-func NmapHandler(ctx context.Context, host string, fromport, endport, iterations, timeout int, throttle bool, jsonoutput *bool) {
-	output := lib.JSONOutput{}
+func NmapHandler(ctx context.Context, host string, fromport, endport, iterations, timeout int, throttle bool, jsonoutput *bool) lib.JSONOutput {
+	params := lib.InputParams{
+		Mode:     "nmap",
+		Host:     host,
+		FromPort: fromport,
+		ToPort:   endport,
+		Protocol: "tcp",
+		Timeout:  timeout,
+		Count:    iterations,
+		Delay:    0,
+		Payload:  0,
+		Throttle: throttle,
+	}
+	return NmapProbeHandler(ctx, params, jsonoutput)
+}
+
+// NmapProbeHandler scans the ports described by params, over UDP when
+// params.Protocol is udp, and prints the results.
+func NmapProbeHandler(ctx context.Context, params lib.InputParams, jsonoutput *bool) lib.JSONOutput {
 	istart := time.Now()
+	output := runNmap(ctx, params, !*jsonoutput)
 	if *jsonoutput {
-		output.InputParams = lib.InputParams{
-			Mode:     "nmap",
-			Host:     host,
-			FromPort: fromport,
-			ToPort:   endport,
-			Protocol: "tcp",
-			Timeout:  timeout,
-			Count:    iterations,
-			Delay:    0,
-			Payload:  0,
-			Throttle: throttle,
+		JS, jsonErr := json.MarshalIndent(output, "", "  ")
+		if jsonErr != nil {
+			fmt.Println(lib.LogWithTimestamp(jsonErr.Error(), true))
+			os.Exit(1)
+		}
+		fmt.Println(string(JS))
+	} else {
+		printInterrupted(output)
+		if params.Protocol == "udp" {
+			printUDPStates(output)
 		}
-		output.ModuleName = "nmap"
-		output.StartTime = istart.UnixMicro()
-		output.Stats = make([]lib.NmapStats, 0)
+		fmt.Println("Total time taken: " + time.Since(istart).String())
 	}
+	return output
+}
 
-	ipaddresses, err := lib.ResolveName(ctx, host) // resolve DNS
+// portName names a scanned port, prefixed with udp for UDP scans.
+func portName(params lib.InputParams, port int) string {
+	if params.Protocol == "udp" {
+		return "udp port " + strconv.Itoa(port)
+	}
+	return "port " + strconv.Itoa(port)
+}
+
+// printUDPStates counts the ports of a UDP scan per state. Ports that did
+// not answer are not listed one by one, they are the bulk of most scans.
+func printUDPStates(output lib.JSONOutput) {
+	stats, _ := output.Stats.([]lib.NmapStats)
+	counts := make(map[string]int)
+	for _, stat := range stats {
+		counts[stat.State]++
+	}
+	fmt.Println("UDP ports open: " + strconv.Itoa(counts[lib.UDPOpen]) + ", open|filtered: " + strconv.Itoa(counts[lib.UDPOpenFiltered]) + ", closed: " + strconv.Itoa(counts[lib.UDPClosed]))
+}
+
+// runNmap scans the port range described by params and collects the results.
+// Open ports are printed as they are found only when verbose is set.
+func runNmap(ctx context.Context, params lib.InputParams, verbose bool) lib.JSONOutput {
+	output := lib.JSONOutput{InputParams: params, ModuleName: "nmap"}
+	host := params.Host
+	istart := time.Now()
+	output.StartTime = istart.UnixMicro()
+	stats := make([]lib.NmapStats, 0)
+
+	dnsctx, cancel := context.WithTimeout(ctx, time.Duration(params.Timeout)*time.Second)
+	ipaddresses, err := lib.ResolveName(dnsctx, host) // resolve DNS
+	cancel()
+	output.DNSLookup = lib.DNSLookup{
+		Hostname:  host,
+		TimeTaken: time.Since(istart).Microseconds(),
+	}
 	if err != nil {
-		if *jsonoutput {
-			output.Error = err.Error()
-		} else {
+		output.Error = err.Error()
+		output.DNSLookup.Error = err.Error()
+		if verbose {
 			fmt.Printf("%s ", lib.LogWithTimestamp(err.Error(), true))
 		}
 	} else { // this is where no error occured in DNS lookup and we can proceed with regular nmap now
-		if !*jsonoutput {
+		output.DNSLookup.Success = true
+		output.DNSLookup.ResolvedAddresses = ipaddresses
+		if verbose {
 			fmt.Println(lib.LogWithTimestamp("DNS lookup successful for "+host+"' to "+strconv.Itoa(len(ipaddresses))+" addresses '["+strings.Join(ipaddresses[:], ", ")+"]' in "+time.Since(istart).String(), false))
-		} else {
-			output.DNSLookup = lib.DNSLookup{
-				Hostname:          host,
-				Success:           true,
-				ResolvedAddresses: ipaddresses,
-				TimeTaken:         time.Since(istart).Microseconds(),
-			}
 		}
 		var WG sync.WaitGroup
 		var MUTEX sync.RWMutex
-		for i := 0; i < iterations; i++ { // loop over the ip addresses for the iterations required
+	scan:
+		for i := 0; i < params.Count; i++ { // loop over the ip addresses for the iterations required
 			for _, ip := range ipaddresses { //  we need to loop over all ip addresses returned, even for once
-				for port := fromport; port <= endport; port++ { // we need to loop over all ports individually
-					if throttle { // check if throttle is enable, then slow things down a bit of random milisecond wait between 0 10000 ms
-						i, err := rand.Int(rand.Reader, big.NewInt(10000))
+				for port := params.FromPort; port <= params.ToPort; port++ { // we need to loop over all ports individually
+					if params.Throttle { // check if throttle is enable, then slow things down a bit of random milisecond wait between 0 10000 ms
+						wait, err := lib.ThrottleDelay()
 						if err != nil {
-							fmt.Println(err)
-							return // added return to exit if error occurs
+							output.Error = err.Error()
+							break scan
 						}
-						time.Sleep(time.Millisecond * time.Duration(i.Int64()))
+						lib.Sleep(ctx, wait)
+					}
+					if ctx.Err() != nil { // interrupted, stop scheduling and let the in-flight probes finish
+						break scan
 					}
 					WG.Add(1)
 					go func(ip string, port int) {
 						defer WG.Done()
-						_, err := lib.IsPortUp(ip, port, timeout) // check if given port from this iteration is up or not
-						if err != nil {
-							if *jsonoutput {
-								MUTEX.Lock()
-								output.Stats = append(output.Stats.([]lib.NmapStats), lib.NmapStats{Address: ip, Port: port, Success: false})
-								MUTEX.Unlock()
-							}
+						stat := lib.NmapStats{Address: ip, Protocol: "tcp", Port: port}
+						if params.Protocol == "udp" {
+							stat.Protocol = "udp"
+							stat.State, _, _, _ = lib.ProbeUDP(ip, port, lib.UDPPayload(port), time.Duration(params.Timeout)*time.Second)
+							stat.Success = stat.State == lib.UDPOpen
 						} else {
-							if *jsonoutput {
-								MUTEX.Lock()
-								output.Stats = append(output.Stats.([]lib.NmapStats), lib.NmapStats{Address: ip, Port: port, Success: true})
-								MUTEX.Unlock()
-							} else {
-								fmt.Println(lib.LogWithTimestamp(ip+" has port "+strconv.Itoa(port)+" open", false))
-							}
+							_, err := lib.IsPortUp(ip, port, params.Timeout) // check if given port from this iteration is up or not
+							stat.Success = err == nil
+						}
+						MUTEX.Lock()
+						stats = append(stats, stat)
+						MUTEX.Unlock()
+						if stat.Success && verbose {
+							fmt.Println(lib.LogWithTimestamp(ip+" has "+portName(params, port)+" open", false))
 						}
 					}(ip, port)
 				}
@@ -92,17 +139,63 @@ func NmapHandler(ctx context.Context, host string, fromport, endport, iterations
 		}
 		WG.Wait()
 	}
+	output.Stats = stats
+	output.Cancelled = ctx.Err() == context.Canceled
+	output.EndTime = time.Now().UnixMicro()
+	output.TotalTimeTaken = output.EndTime - output.StartTime
+	return output
+}
 
+// NmapDiffHandler compares two nmap results and prints the ports that were
+// opened or closed and the hosts that appeared or disappeared in between.
+func NmapDiffHandler(previous, current lib.JSONOutput, jsonoutput *bool) lib.NmapDiff {
+	diff := lib.DiffNmap(lib.NmapResults(previous), lib.NmapResults(current))
 	if *jsonoutput {
-		output.EndTime = time.Now().UnixMicro()
-		output.TotalTimeTaken = output.EndTime - output.StartTime
-		JS, jsonErr := json.MarshalIndent(output, "", "  ")
-		if jsonErr != nil {
-			fmt.Println(lib.LogWithTimestamp(jsonErr.Error(), true))
-			os.Exit(1)
-		}
+		JS, _ := json.MarshalIndent(diff, "", "  ")
+		fmt.Println(string(JS))
+	} else {
+		printNmapDiff(diff)
+	}
+	return diff
+}
+
+// NmapBaselineHandler scans like NmapHandler and compares the result with a
+// baseline scan. In JSON mode the diff and the scan are printed together.
+func NmapBaselineHandler(ctx context.Context, params lib.InputParams, baseline lib.JSONOutput, jsonoutput *bool) (lib.JSONOutput, lib.NmapDiff) {
+	istart := time.Now()
+	output := runNmap(ctx, params, !*jsonoutput)
+	diff := lib.DiffNmap(lib.NmapResults(baseline), lib.NmapResults(output))
+	if *jsonoutput {
+		JS, _ := json.MarshalIndent(struct {
+			SchemaVersion int            `json:"schema_version"`
+			Diff          lib.NmapDiff   `json:"diff"`
+			Result        lib.JSONOutput `json:"result"`
+		}{lib.SchemaVersion, diff, output}, "", "  ")
 		fmt.Println(string(JS))
 	} else {
+		printInterrupted(output)
+		printNmapDiff(diff)
 		fmt.Println("Total time taken: " + time.Since(istart).String())
 	}
+	return output, diff
+}
+
+func printNmapDiff(diff lib.NmapDiff) {
+	fmt.Println("\n" + strings.Repeat("=", 42) + " nmap DIFF " + strings.Repeat("=", 42))
+	for _, host := range diff.AppearedHosts {
+		fmt.Println("+ host " + host + " appeared")
+	}
+	for _, host := range diff.DisappearedHosts {
+		fmt.Println("- host " + host + " disappeared")
+	}
+	for _, stat := range diff.OpenedPorts {
+		fmt.Println("+ " + stat.Address + " port " + strconv.Itoa(stat.Port) + "/" + stat.Protocol + " is newly open")
+	}
+	for _, stat := range diff.ClosedPorts {
+		fmt.Println("- " + stat.Address + " port " + strconv.Itoa(stat.Port) + "/" + stat.Protocol + " is newly closed")
+	}
+	fmt.Println("Newly open ports: " + strconv.Itoa(len(diff.OpenedPorts)) + ", newly closed ports: " + strconv.Itoa(len(diff.ClosedPorts)) + ", hosts appeared: " + strconv.Itoa(len(diff.AppearedHosts)) + ", hosts disappeared: " + strconv.Itoa(len(diff.DisappearedHosts)))
+	if diff.ExposureGrew {
+		fmt.Println(lib.LogWithTimestamp("Exposure grew since the baseline", true))
+	}
 }
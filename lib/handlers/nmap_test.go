@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TestNmapInterrupted cancels a throttled scan once its first port was
+// probed and checks that the scan stops there with the result of that port.
+// The throttle waits up to 10 seconds before the first port.
+func TestNmapInterrupted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the random delay of --throttle")
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	port := listenAndCancel(t, 1, cancel, func(conn net.Conn) { conn.Close() })
+	params := lib.InputParams{Mode: "nmap", Host: "127.0.0.1", FromPort: port, ToPort: port + 9, Protocol: "tcp", Count: 1, Timeout: 2, Throttle: true}
+
+	output := runNmap(ctx, params, false)
+	stats := output.Stats.([]lib.NmapStats)
+	if !output.Cancelled || len(stats) != 1 {
+		t.Fatalf("Expected a cancelled scan of the first of 10 ports, got %+v", output)
+	}
+	if stats[0].Port != port || !stats[0].Success || stats[0].Protocol != "tcp" {
+		t.Errorf("Expected port %d open, got %+v", port, stats[0])
+	}
+}
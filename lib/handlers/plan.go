@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// RunPlanHandler executes every check of plan with at most parallel checks in
+// flight, prints the combined report and returns it. Every check result is
+// also handed to report, which main uses for --notify. Once ctx is cancelled
+// no further checks are started and the report only covers those that ran.
+func RunPlanHandler(ctx context.Context, plan lib.Plan, parallel int, jsonoutput *bool, report func(lib.JSONOutput)) lib.PlanReport {
+	if parallel < 1 {
+		parallel = 1
+	}
+	istart := time.Now()
+	result := lib.PlanReport{Name: plan.Name, StartTime: istart.UnixMicro(), Checks: make([]lib.PlanResult, len(plan.Checks))}
+	var WG sync.WaitGroup
+	var MUTEX sync.Mutex
+	slots := make(chan struct{}, parallel)
+	started := 0
+schedule:
+	for i, check := range plan.Checks {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break schedule
+		}
+		if ctx.Err() != nil {
+			<-slots
+			break
+		}
+		started++
+		WG.Add(1)
+		go func(i int, check lib.PlanCheck) {
+			defer WG.Done()
+			defer func() { <-slots }()
+			output := Probe(ctx, check.InputParams)
+			failures := check.Verify(output)
+			result.Checks[i] = lib.PlanResult{Name: check.Name, Passed: len(failures) == 0, Failures: failures, Result: output}
+			if report != nil {
+				MUTEX.Lock()
+				report(output)
+				MUTEX.Unlock()
+			}
+			if !*jsonoutput {
+				MUTEX.Lock()
+				fmt.Println(lib.LogWithTimestamp(planLine(result.Checks[i]), false))
+				MUTEX.Unlock()
+			}
+		}(i, check)
+	}
+	WG.Wait()
+	result.Checks = result.Checks[:started]
+	result.Cancelled = ctx.Err() == context.Canceled
+	for _, check := range result.Checks {
+		if check.Passed {
+			result.Passed++
+		} else {
+			result.Failed++
+		}
+	}
+	result.EndTime = time.Now().UnixMicro()
+	result.TotalTimeTaken = result.EndTime - result.StartTime
+
+	if *jsonoutput {
+		JS, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(JS))
+	} else {
+		name := plan.Name
+		if name == "" {
+			name = "plan"
+		}
+		if result.Cancelled {
+			fmt.Println(lib.LogWithTimestamp("Interrupted, "+strconv.Itoa(started)+" of "+strconv.Itoa(len(plan.Checks))+" checks were run", false))
+		}
+		rule := strings.Repeat("=", max(0, 45-len(name))) // long names get no rule
+		fmt.Println("\n" + rule + " " + name + " REPORT " + rule)
+		for _, check := range result.Checks {
+			fmt.Println(planLine(check))
+			for _, failure := range check.Failures {
+				fmt.Println("        - " + failure)
+			}
+		}
+		fmt.Println("Checks: " + strconv.Itoa(len(result.Checks)) + ", Passed: " + strconv.Itoa(result.Passed) + ", Failed: " + strconv.Itoa(result.Failed))
+		fmt.Println("Total time taken: " + time.Since(istart).String())
+	}
+	return result
+}
+
+// planLine renders one check result as a single report line.
+func planLine(check lib.PlanResult) string {
+	status := "PASS"
+	if !check.Passed {
+		status = "FAIL"
+	}
+	summary := lib.Summarize(check.Result)
+	line := status + "  " + check.Name + " (" + check.Result.ModuleName + " " + check.Result.InputParams.Target() + "): " + strconv.Itoa(summary.Succeeded) + "/" + strconv.Itoa(summary.Sent) + " succeeded"
+	if len(summary.Latencies) > 0 {
+		_, avg, _ := lib.GetMinAvgMax(summary.Latencies)
+		line += ", average latency " + avg.String()
+	}
+	return line
+}
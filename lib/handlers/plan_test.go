@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TestRunPlanHandlerInterrupted interrupts a run before any check completes
+// and checks that it fails instead of passing with no checks.
+func TestRunPlanHandlerInterrupted(t *testing.T) {
+	check := lib.PlanCheck{Name: "loopback", InputParams: lib.InputParams{Mode: "telnet", Host: "127.0.0.1", FromPort: 1}}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	jsonoutput := true
+	report := RunPlanHandler(ctx, lib.Plan{Checks: []lib.PlanCheck{check, check}}, 1, &jsonoutput, nil)
+	if !report.Cancelled || report.ExitCode() != lib.ExitFailure {
+		t.Errorf("Expected a cancelled, failed run, got %+v with exit code %d", report, report.ExitCode())
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// Probe runs the module named by params.Mode once and returns its result
+// without printing anything. It is the entry point for callers that need the
+// results themselves rather than the command line output.
+func Probe(ctx context.Context, params lib.InputParams) lib.JSONOutput {
+	switch params.Mode {
+	case "telnet":
+		return runTelnet(ctx, params, false)
+	case "icmp", "ping":
+		params.Mode = "icmp"
+		return runICMP(ctx, params, false)
+	case "web":
+		return runWeb(ctx, params, false)
+	case "nmap":
+		return runNmap(ctx, params, false)
+	case "trace":
+		return runTrace(ctx, params, false)
+	case "mtu":
+		return runMTU(ctx, params, false)
+	}
+	now := time.Now().UnixMicro()
+	return lib.JSONOutput{
+		InputParams: params,
+		ModuleName:  params.Mode,
+		StartTime:   now,
+		EndTime:     now,
+		Error:       "unknown module '" + params.Mode + "'",
+	}
+}
+
+// printInterrupted tells the user that the statistics that follow are partial
+// because the run was interrupted.
+func printInterrupted(output lib.JSONOutput) {
+	if output.Cancelled {
+		fmt.Println(lib.LogWithTimestamp("Interrupted, statistics below only cover the probes completed so far", false))
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// ReplayHandler runs the probe recorded in original again with the same input
+// params and prints a side by side comparison of success and latency.
+func ReplayHandler(ctx context.Context, original lib.JSONOutput, jsonoutput *bool) lib.JSONOutput {
+	comparison := lib.ReplayComparison{
+		Command:  lib.CommandLine(original.InputParams),
+		Original: lib.NewReplaySide(original),
+	}
+	if !*jsonoutput {
+		fmt.Println(lib.LogWithTimestamp("Replaying: "+comparison.Command, false))
+	}
+	output := Probe(ctx, original.InputParams)
+	comparison.Replay = lib.NewReplaySide(output)
+	comparison.Result = output
+
+	if *jsonoutput {
+		JS, _ := json.MarshalIndent(comparison, "", "  ")
+		fmt.Println(string(JS))
+		return output
+	}
+	if output.Error != "" {
+		fmt.Println(lib.LogWithTimestamp(output.Error, true))
+	}
+	was, now := comparison.Original, comparison.Replay
+	row := func(label, before, after, change string) {
+		fmt.Printf("%-18s %-18s %-18s %s\n", label, before, after, change)
+	}
+	fmt.Println("\n" + strings.Repeat("=", 40) + " REPLAY COMPARISON " + strings.Repeat("=", 40))
+	row("", "original", "replay", "change")
+	row("Requests sent", strconv.Itoa(was.Sent), strconv.Itoa(now.Sent), "")
+	row("Succeeded", strconv.Itoa(was.Succeeded), strconv.Itoa(now.Succeeded), "")
+	row("Success rate", strconv.FormatFloat(was.SuccessRate, 'f', 1, 64)+"%", strconv.FormatFloat(now.SuccessRate, 'f', 1, 64)+"%", signed(now.SuccessRate-was.SuccessRate, "%"))
+	latency := func(label string, before, after int64) {
+		b, a := time.Duration(before)*time.Microsecond, time.Duration(after)*time.Microsecond
+		row(label, b.String(), a.String(), signed(float64((a-b).Microseconds())/1000, "ms"))
+	}
+	latency("Latency minimum", was.MinLatency, now.MinLatency)
+	latency("Latency average", was.AvgLatency, now.AvgLatency)
+	latency("Latency maximum", was.MaxLatency, now.MaxLatency)
+	return output
+}
+
+// signed formats a difference with an explicit sign.
+func signed(value float64, unit string) string {
+	text := strconv.FormatFloat(value, 'f', 3, 64)
+	if value >= 0 {
+		text = "+" + text
+	}
+	return text + unit
+}
@@ -2,10 +2,10 @@ package handlers
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/json"
 	"fmt"
-	"math/big"
+	"net"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,10 +14,8 @@ import (
 	"github.com/dmartsapp/shint/lib"
 )
 
-func TelnetHandler(jsonoutput *bool, iterations int, delay int, throttle *bool, timeout int, payload_size int, port int, CTXTIMEOUT context.Context, host string) {
-	var MUTEX sync.RWMutex
-	output := lib.JSONOutput{}
-	output.InputParams = lib.InputParams{
+func TelnetHandler(jsonoutput *bool, iterations int, delay int, throttle *bool, timeout int, payload_size int, port int, CTXTIMEOUT context.Context, host string) lib.JSONOutput {
+	params := lib.InputParams{
 		Mode:     "telnet",
 		Host:     host,
 		FromPort: int(port),
@@ -29,99 +27,195 @@ func TelnetHandler(jsonoutput *bool, iterations int, delay int, throttle *bool,
 		Payload:  payload_size,
 		Throttle: *throttle,
 	}
-	output.ModuleName = "telnet"
-	istart := time.Now()                                  // capture initial time
-	ipaddresses, err := lib.ResolveName(CTXTIMEOUT, host) // resolve DNS
-	var stats = make([]time.Duration, 0)
-	if err != nil {
-		if *jsonoutput {
-			output.DNSLookup = lib.DNSLookup{
-				Hostname:          host,
-				Success:           false,
-				ResolvedAddresses: nil,
-				TimeTaken:         time.Since(istart).Microseconds(),
+	return TelnetProbeHandler(CTXTIMEOUT, params, jsonoutput)
+}
+
+// TelnetProbeHandler runs the telnet checks described by params, including a
+// payload exchange when params.Send or params.RandomPayload is set, and prints
+// the results.
+func TelnetProbeHandler(ctx context.Context, params lib.InputParams, jsonoutput *bool) lib.JSONOutput {
+	istart := time.Now()
+	output := runTelnet(ctx, params, !*jsonoutput)
+	if *jsonoutput {
+		JS, _ := json.MarshalIndent(output, "", "  ")
+		fmt.Println(string(JS))
+	} else {
+		printInterrupted(output)
+		if output.DNSLookup.Success {
+			summary := lib.Summarize(output)
+			fmt.Println(lib.LogStats("telnet", summary.Latencies, summary.Sent))
+			if params.Send != "" || params.RandomPayload || params.Protocol == "udp" {
+				printAppRTT(output)
 			}
 		} else {
+			fmt.Println(lib.LogStats("telnet", nil, params.Count))
+		}
+		fmt.Println("Total time taken: " + time.Since(istart).String())
+	}
+	return output
+}
+
+// runTelnet performs the TCP connect checks described by params and collects the
+// results. Progress lines are printed as they happen only when verbose is set.
+func runTelnet(ctx context.Context, params lib.InputParams, verbose bool) lib.JSONOutput {
+	var MUTEX sync.RWMutex
+	output := lib.JSONOutput{InputParams: params, ModuleName: "telnet"}
+	host, port, delay := params.Host, params.FromPort, params.Delay
+	expect, err := params.ExpectPattern()
+	if err != nil {
+		output.Error = err.Error()
+		return output
+	}
+	istart := time.Now() // capture initial time
+	output.StartTime = istart.UnixMicro()
+	dnsctx, cancel := context.WithTimeout(ctx, time.Duration(params.Timeout)*time.Second)
+	ipaddresses, err := lib.ResolveName(dnsctx, host) // resolve DNS
+	cancel()
+	output.DNSLookup = lib.DNSLookup{
+		Hostname:  host,
+		TimeTaken: time.Since(istart).Microseconds(),
+	}
+	if err != nil {
+		output.DNSLookup.Error = err.Error()
+		output.Error = err.Error()
+		if verbose {
 			fmt.Printf("%s ", lib.LogWithTimestamp(err.Error(), true))
-			fmt.Println(lib.LogStats("telnet", stats, iterations))
 		}
 	} else {
-		if !*jsonoutput {
+		output.DNSLookup.Success = true
+		output.DNSLookup.ResolvedAddresses = ipaddresses
+		if verbose {
 			fmt.Println(lib.LogWithTimestamp("DNS lookup successful for "+host+"' to "+strconv.Itoa(len(ipaddresses))+" addresses '["+strings.Join(ipaddresses[:], ", ")+"]' in "+time.Since(istart).String(), false))
-		} else {
-			output.DNSLookup = lib.DNSLookup{
-				Hostname:          host,
-				Success:           true,
-				ResolvedAddresses: ipaddresses,
-				TimeTaken:         time.Since(istart).Microseconds(),
-			}
 		}
 		var WG sync.WaitGroup
-		if *jsonoutput {
-			output.Stats = make([]lib.TelnetStats, 0)
-			output.StartTime = istart.UnixMicro()
-		}
-		for i := 0; i < iterations; i++ { // loop over the ip addresses for the iterations required
+		stats := make([]lib.TelnetStats, 0)
+	schedule:
+		for i := 0; i < params.Count; i++ { // loop over the ip addresses for the iterations required
 			for _, ip := range ipaddresses { //  we need to loop over all ip addresses returned, even for once
-				if *throttle { // check if throttle is enable, then slow things down a bit of random milisecond wait between 0 1000 ms
-					// time.Sleep(time.Millisecond * time.Duration(rand.Intn(10000)))
-					in, err := rand.Int(rand.Reader, big.NewInt(10000))
-					if err != nil {
+				wait := time.Millisecond * time.Duration(delay)
+				if params.Throttle { // check if throttle is enable, then slow things down a bit of random milisecond wait between 0 10000 ms
+					if wait, err = lib.ThrottleDelay(); err != nil {
 						fmt.Println(err)
 					}
-					delay = int(in.Int64())
 				}
-				time.Sleep(time.Millisecond * time.Duration(delay))
+				if !lib.Sleep(ctx, wait) { // interrupted, stop scheduling and let the in-flight probes finish
+					break schedule
+				}
 				WG.Add(1)
 				go func(ip string) {
 					defer WG.Done()
-					start := time.Now()                            // capture initial time
-					_, err := lib.IsPortUp(ip, int(port), timeout) // check if given port from this iteration is up or not
-					if err != nil {
-						if *jsonoutput {
-							stat := lib.TelnetStats{}
-							stat.Address = ip
-							stat.Success = false
-							stat.TimeTaken = time.Since(start).Microseconds()
-							output.Stats = append(output.Stats.([]lib.TelnetStats), stat)
-						} else {
-							fmt.Println(lib.LogWithTimestamp(err.Error()+" Time taken: "+time.Since(start).String(), true))
+					stat := probeTelnet(ip, port, params, expect)
+					time_taken := time.Duration(stat.TimeTaken) * time.Microsecond
+					if !stat.Success {
+						if verbose {
+							fmt.Println(lib.LogWithTimestamp(stat.Error+" Time taken: "+time_taken.String(), true))
 						}
-					} else {
-						MUTEX.Lock()
-						time_taken := time.Since(start) //capture the time taken
-						stats = append(stats, time_taken)
-						defer MUTEX.Unlock()
-						if *jsonoutput {
-							stat := lib.TelnetStats{}
-							stat.Address = ip
-							stat.Success = true
-							stat.SentTime = start.UnixMicro()
-							stat.RecvTime = time.Now().UnixMicro()
-							stat.TimeTaken = time.Since(start).Microseconds()
-							output.Stats = append(output.Stats.([]lib.TelnetStats), stat)
-						} else {
-							fmt.Println(lib.LogWithTimestamp("Successfully connected to "+ip+" on port "+strconv.Itoa(int(port))+" after "+time_taken.String(), false))
+					} else if verbose {
+						message := "Successfully connected to " + ip + " on port " + strconv.Itoa(int(port)) + " after " + (time.Duration(stat.ConnectTime) * time.Microsecond).String()
+						if params.Protocol == "udp" {
+							message = ip + " has udp port " + strconv.Itoa(int(port)) + " open"
 						}
-
+						if stat.BytesSent > 0 || stat.BytesReceived > 0 {
+							message += ", sent " + strconv.Itoa(stat.BytesSent) + " bytes and received " + strconv.Itoa(stat.BytesReceived) + " bytes in " + (time.Duration(stat.AppRTT) * time.Microsecond).String()
+						}
+						fmt.Println(lib.LogWithTimestamp(message, false))
 					}
+					MUTEX.Lock()
+					stats = append(stats, stat)
+					MUTEX.Unlock()
 				}(ip)
 			}
 		}
 		WG.Wait()
-		if !*jsonoutput {
-			MUTEX.RLock()
-			fmt.Println(lib.LogStats("telnet", stats, (iterations * len(ipaddresses))))
-			MUTEX.RUnlock()
-		}
+		output.Stats = stats
 	}
+	output.Cancelled = ctx.Err() == context.Canceled
+	output.EndTime = time.Now().UnixMicro()
+	output.TotalTimeTaken = output.EndTime - output.StartTime
+	return output
+}
 
-	if *jsonoutput {
-		output.EndTime = time.Now().UnixMicro()
-		output.TotalTimeTaken = output.EndTime - output.StartTime
-		JS, _ := json.MarshalIndent(output, "", "  ")
-		fmt.Println(string(JS))
-	} else {
-		fmt.Println("Total time taken: " + time.Since(istart).String())
+// probeTelnet connects to ip on port and, when params carry a payload, sends
+// it and waits for the expected response on the same connection.
+func probeTelnet(ip string, port int, params lib.InputParams, expect *regexp.Regexp) lib.TelnetStats {
+	stat := lib.TelnetStats{Address: ip}
+	timeout := time.Duration(params.Timeout) * time.Second
+	if params.Protocol == "udp" {
+		return probeTelnetUDP(stat, port, params, expect, timeout)
+	}
+	start := time.Now() // capture initial time
+	conn, err := net.DialTimeout(lib.Protocol, net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+	connected := time.Since(start) //capture the time taken
+	stat.ConnectTime, stat.TimeTaken = connected.Microseconds(), connected.Microseconds()
+	if err != nil {
+		stat.Error = err.Error()
+		return stat
+	}
+	defer conn.Close()
+	stat.SentTime = start.UnixMicro()
+	payload, err := params.SendPayload()
+	if err == nil && (len(payload) > 0 || expect != nil) {
+		var response []byte
+		var rtt time.Duration
+		response, rtt, err = lib.Exchange(conn, payload, expect, timeout)
+		stat.AppRTT, stat.BytesSent, stat.BytesReceived = rtt.Microseconds(), len(payload), len(response)
+		stat.Response = string(lib.Truncate(response, 256))
+		stat.TimeTaken = time.Since(start).Microseconds()
+	}
+	if err != nil {
+		stat.Error = err.Error()
+		return stat
+	}
+	stat.Success = true
+	stat.RecvTime = start.Add(time.Duration(stat.TimeTaken) * time.Microsecond).UnixMicro()
+	return stat
+}
+
+// probeTelnetUDP sends the payload of params, or the protocol aware request
+// for the port, as a datagram and classifies the port from the reply. Only an
+// open port matching the expectation counts as a success.
+func probeTelnetUDP(stat lib.TelnetStats, port int, params lib.InputParams, expect *regexp.Regexp, timeout time.Duration) lib.TelnetStats {
+	payload, err := params.SendPayload()
+	if err != nil {
+		stat.Error = err.Error()
+		return stat
+	}
+	if payload == nil {
+		payload = lib.UDPPayload(port)
+	}
+	start := time.Now()
+	state, response, rtt, err := lib.ProbeUDP(stat.Address, port, payload, timeout)
+	stat.State, stat.BytesSent, stat.BytesReceived = state, len(payload), len(response)
+	stat.Response = string(lib.Truncate(response, 256))
+	stat.SentTime, stat.AppRTT, stat.TimeTaken = start.UnixMicro(), rtt.Microseconds(), rtt.Microseconds()
+	switch {
+	case err != nil:
+		stat.Error = err.Error()
+	case state == lib.UDPClosed:
+		stat.Error = "udp port " + strconv.Itoa(port) + " is closed (ICMP port unreachable)"
+	case state == lib.UDPOpenFiltered:
+		stat.Error = "no reply from udp port " + strconv.Itoa(port) + ", it is open|filtered"
+	case expect != nil && !expect.Match(response):
+		stat.Error = "response does not match '" + expect.String() + "'"
+	default:
+		stat.Success = true
+		stat.RecvTime = start.Add(rtt).UnixMicro()
+	}
+	return stat
+}
+
+// printAppRTT prints the application round trip statistics of the telnet
+// checks that exchanged a payload.
+func printAppRTT(output lib.JSONOutput) {
+	stats, _ := output.Stats.([]lib.TelnetStats)
+	rtts := make([]time.Duration, 0)
+	for _, stat := range stats {
+		if stat.Success {
+			rtts = append(rtts, time.Duration(stat.AppRTT)*time.Microsecond)
+		}
+	}
+	if len(rtts) > 0 {
+		min, avg, max := lib.GetMinAvgMax(rtts)
+		fmt.Println("Application RTT: minimum: " + min.String() + ", average: " + avg.String() + ", maximum: " + max.String())
 	}
 }
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TestTelnetExchange checks the payload exchange and expectation of telnet
+// against a line based server answering PING with PONG.
+func TestTelnetExchange(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				if strings.TrimSpace(line) == "PING" {
+					conn.Write([]byte("+PONG\r\n"))
+				} else {
+					conn.Write([]byte("-ERR unknown command\r\n"))
+				}
+			}(conn)
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	params := lib.InputParams{Mode: "telnet", Host: "127.0.0.1", FromPort: port, ToPort: port, Protocol: "tcp", Timeout: 2, Count: 1, Send: "PING\r\n", Expect: "PONG"}
+
+	stats := runTelnet(t.Context(), params, false).Stats.([]lib.TelnetStats)
+	if len(stats) != 1 || !stats[0].Success || stats[0].BytesSent != 6 || stats[0].Response != "+PONG\r\n" || stats[0].AppRTT <= 0 {
+		t.Errorf("Unexpected stats for PING %+v", stats)
+	}
+
+	params.Send = "QUIT\r\n"
+	stats = runTelnet(t.Context(), params, false).Stats.([]lib.TelnetStats)
+	if len(stats) != 1 || stats[0].Success || !strings.Contains(stats[0].Error, "does not match") {
+		t.Errorf("Unexpected stats for QUIT %+v", stats)
+	}
+
+	params.Send, params.Expect, params.ExpectRegex = "PING\n", `^\+PO[N]G\r\n$`, true
+	stats = runTelnet(t.Context(), params, false).Stats.([]lib.TelnetStats)
+	if len(stats) != 1 || !stats[0].Success {
+		t.Errorf("Unexpected stats for the regular expression %+v on port %s", stats, strconv.Itoa(port))
+	}
+}
+
+// listenAndCancel accepts connections on a loopback port, hands them to serve
+// and calls cancel when the connection number n comes in.
+func listenAndCancel(t *testing.T, n int32, cancel context.CancelFunc, serve func(net.Conn)) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if accepted.Add(1) == n {
+				cancel()
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// TestTelnetInterrupted cancels a run during its second exchange and checks
+// that the exchange in flight completes and nothing is scheduled after it.
+func TestTelnetInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	port := listenAndCancel(t, 2, cancel, func(conn net.Conn) {
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		time.Sleep(100 * time.Millisecond) // answer after the cancellation
+		conn.Write([]byte("+PONG\r\n"))
+	})
+	params := lib.InputParams{Mode: "telnet", Host: "127.0.0.1", FromPort: port, ToPort: port, Protocol: "tcp", Timeout: 2, Count: 50, Delay: 10, Send: "PING\r\n", Expect: "PONG"}
+
+	output := runTelnet(ctx, params, false)
+	stats := output.Stats.([]lib.TelnetStats)
+	if !output.Cancelled || len(stats) < 2 || len(stats) == params.Count {
+		t.Fatalf("Expected a cancelled run with part of the %d exchanges, got %d in %+v", params.Count, len(stats), output)
+	}
+	for _, stat := range stats {
+		if !stat.Success {
+			t.Errorf("Expected the exchanges in flight to complete, got %+v", stat)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+)
+
+// TraceHandler discovers the path to params.Host, with TCP SYNs to
+// params.FromPort when it is set and with ICMP echo requests otherwise, and
+// prints one line per hop like mtr.
+func TraceHandler(ctx context.Context, params lib.InputParams, jsonoutput *bool) lib.JSONOutput {
+	output := runTrace(ctx, params, !*jsonoutput)
+	if *jsonoutput {
+		JS, jsonErr := json.MarshalIndent(output, "", "  ")
+		if jsonErr != nil {
+			fmt.Println(lib.LogWithTimestamp(jsonErr.Error(), true))
+			os.Exit(1)
+		}
+		fmt.Println(string(JS))
+		return output
+	}
+	if output.Error != "" {
+		fmt.Println(lib.LogWithTimestamp(output.Error, true))
+	}
+	printInterrupted(output)
+	stats, _ := output.Stats.([]lib.TraceStats)
+	if len(stats) > 0 {
+		fmt.Printf("%-4s %-16s %-40s %6s %5s %9s %9s %9s %9s\n", "Hop", "Address", "Hostname", "Loss%", "Sent", "Avg", "Best", "Worst", "StDev")
+	}
+	for _, hop := range stats {
+		if hop.Received == 0 {
+			fmt.Printf("%-4d %-16s %-40s %5.1f%% %5d\n", hop.TTL, "???", "", hop.Loss, hop.Sent)
+			continue
+		}
+		fmt.Printf("%-4d %-16s %-40s %5.1f%% %5d %9s %9s %9s %9s\n", hop.TTL, hop.Address, hop.Hostname, hop.Loss, hop.Sent,
+			traceMillis(hop.Avg), traceMillis(hop.Min), traceMillis(hop.Max), traceMillis(hop.StdDev))
+		for _, address := range hop.Addresses[1:] { // other routers answering for the same hop
+			fmt.Printf("%-4s %-16s\n", "", address)
+		}
+	}
+	if len(stats) > 0 && !stats[len(stats)-1].Reached {
+		fmt.Println(lib.LogWithTimestamp(params.Host+" was not reached within "+strconv.Itoa(params.MaxHops)+" hops", true))
+	}
+	fmt.Println("Total time taken: " + (time.Duration(output.TotalTimeTaken) * time.Microsecond).String())
+	return output
+}
+
+// traceMillis formats a latency in microseconds as milliseconds.
+func traceMillis(micros int64) string {
+	return strconv.FormatFloat(float64(micros)/1000, 'f', 1, 64) + "ms"
+}
+
+// runTrace resolves params.Host and traces the path to its first IPv4
+// address. The hops are collected once all queries are done.
+func runTrace(ctx context.Context, params lib.InputParams, verbose bool) lib.JSONOutput {
+	output := lib.JSONOutput{InputParams: params, ModuleName: "trace"}
+	start := time.Now()
+	output.StartTime = start.UnixMicro()
+	finish := func() lib.JSONOutput {
+		output.Cancelled = ctx.Err() == context.Canceled
+		output.EndTime = time.Now().UnixMicro()
+		output.TotalTimeTaken = output.EndTime - output.StartTime
+		return output
+	}
+	dnsctx, cancel := context.WithTimeout(ctx, time.Duration(params.Timeout)*time.Second)
+	ips, err := lib.ResolveNameToIPs(dnsctx, params.Host)
+	cancel()
+	output.DNSLookup = lib.DNSLookup{
+		Hostname:          params.Host,
+		Success:           err == nil,
+		ResolvedAddresses: lib.ConvertIPToStringSlice(ips),
+		TimeTaken:         time.Since(start).Microseconds(),
+	}
+	if err != nil {
+		output.Error = "Unable to resolve the name for '" + params.Host + "'"
+		output.DNSLookup.Error = err.Error()
+		return finish()
+	}
+	var target net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			target = ip
+			break
+		}
+	}
+	if target == nil {
+		output.Error = params.Host + " has no IPv4 address to trace"
+		return finish()
+	}
+	if verbose {
+		method := "ICMP"
+		if params.FromPort > 0 {
+			method = "TCP port " + strconv.Itoa(params.FromPort)
+		}
+		fmt.Println(lib.LogWithTimestamp("Tracing the path to "+params.Host+" ("+target.String()+") with "+method+", "+strconv.Itoa(params.Count)+" queries per hop, at most "+strconv.Itoa(params.MaxHops)+" hops", false))
+	}
+	hops, err := lib.Trace(ctx, target, lib.TraceOptions{
+		MaxHops: params.MaxHops,
+		Queries: params.Count,
+		Port:    params.FromPort,
+		Timeout: time.Duration(params.Timeout) * time.Second,
+		Payload: params.Payload,
+	})
+	if err != nil {
+		output.Error = err.Error()
+	} else {
+		output.Stats = hops
+	}
+	return finish()
+}
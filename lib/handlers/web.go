@@ -1,130 +1,236 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
-	"math/big"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/dmartsapp/shint/lib"
+	"github.com/quic-go/quic-go/http3"
 )
 
 const (
 	HTTP_CLIENT_USER_AGENT string = "dmarts.app-http-v0.1"
 )
 
-func WebHandler(jsonoutput *bool, iterations int, delay int, throttle *bool, timeout int, URL *url.URL, method string, data string, headers []string, includeresponsebody bool) {
-	output := lib.JSONOutput{}
+// webRootCAs are the certificate authorities trusted by the web module, nil
+// for the ones of the system.
+var webRootCAs *x509.CertPool
+
+func WebHandler(ctx context.Context, jsonoutput *bool, iterations int, delay int, throttle *bool, timeout int, URL *url.URL, method string, data string, headers []string, includeresponsebody bool) lib.JSONOutput {
+	params := lib.InputParams{
+		Mode:     "web",
+		Host:     URL.Host,
+		URL:      URL.String(),
+		Protocol: "tcp",
+		Timeout:  timeout,
+		Count:    iterations,
+		Delay:    delay,
+		Payload:  len(data) + len(headers),
+		Throttle: *throttle,
+		Method:   method,
+		Data:     data,
+		Headers:  headers,
+		WithBody: includeresponsebody,
+	}
+	return WebProbeHandler(ctx, params, jsonoutput)
+}
+
+// WebProbeHandler runs the web module with params and prints its result.
+func WebProbeHandler(ctx context.Context, params lib.InputParams, jsonoutput *bool) lib.JSONOutput {
 	istart := time.Now()
-	var stats = make([]time.Duration, 0)
+	output := runWeb(ctx, params, !*jsonoutput)
+	if *jsonoutput {
+		JS, jsonErr := json.MarshalIndent(output, "", "  ")
+		if jsonErr != nil {
+			fmt.Println(lib.LogWithTimestamp(jsonErr.Error(), true))
+			os.Exit(1)
+		}
+		fmt.Println(string(JS))
+	} else {
+		if output.Error != "" {
+			fmt.Println(lib.LogWithTimestamp(output.Error, true))
+		}
+		printInterrupted(output)
+		summary := lib.Summarize(output)
+		fmt.Println(lib.LogStats("web", summary.Latencies, summary.Sent))
+		if output.Reuse != nil {
+			printWebLatency("Cold (new connection)", output.Reuse.Cold)
+			printWebLatency("Warm (reused connection)", output.Reuse.Warm)
+		}
+		fmt.Println("Total time taken: " + time.Since(istart).String())
+	}
+	return output
+}
+
+// printWebLatency prints the latency of the cold or warm requests.
+func printWebLatency(label string, latency lib.WebLatency) {
+	if latency.Requests == 0 {
+		fmt.Printf("%s: no requests\n", label)
+		return
+	}
+	duration := func(micros int64) string { return (time.Duration(micros) * time.Microsecond).String() }
+	fmt.Printf("%s: %d requests, minimum: %s, average: %s, maximum: %s\n", label, latency.Requests, duration(latency.Min), duration(latency.Avg), duration(latency.Max))
+}
+
+// webTransport returns the round tripper speaking the HTTP version asked for,
+// HTTP/1.1 or HTTP/2 as negotiated by ALPN when version is empty.
+func webTransport(version string) http.RoundTripper {
+	config := &tls.Config{RootCAs: webRootCAs, MinVersion: tls.VersionTLS12}
+	if version == lib.HTTP3 {
+		return &http3.Transport{TLSClientConfig: config}
+	}
+	transport := &http.Transport{TLSClientConfig: config, Protocols: new(http.Protocols)}
+	switch version {
+	case lib.HTTP11:
+		transport.Protocols.SetHTTP1(true)
+	case lib.HTTP2:
+		transport.Protocols.SetHTTP2(true)
+	case lib.H2C:
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	default:
+		transport.Protocols.SetHTTP1(true)
+		transport.Protocols.SetHTTP2(true)
+	}
+	return transport
+}
+
+// runWeb issues the HTTP requests described by params and collects the results.
+// Responses are printed as they arrive only when verbose is set.
+func runWeb(ctx context.Context, params lib.InputParams, verbose bool) lib.JSONOutput {
+	output := lib.JSONOutput{InputParams: params.Redacted(), ModuleName: "web"}
+	istart := time.Now()
+	output.StartTime = istart.UnixMicro()
 	var MUTEX sync.RWMutex
 
-	if !*jsonoutput {
-		ipaddresses, err := lib.ResolveName(context.Background(), URL.Hostname())
-		if err != nil {
+	URL, err := url.Parse(params.URL)
+	if err != nil {
+		output.Error = err.Error()
+		output.EndTime = time.Now().UnixMicro()
+		output.TotalTimeTaken = output.EndTime - output.StartTime
+		return output
+	}
+	body, contentType, err := params.RequestBody()
+	if err != nil {
+		output.Error = err.Error()
+		output.EndTime = time.Now().UnixMicro()
+		output.TotalTimeTaken = output.EndTime - output.StartTime
+		return output
+	}
+	hash := lib.BodyHash(body)
+	method, headers := params.Method, params.Headers
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	output.DNSLookup = lib.DNSLookup{
+		Hostname: URL.Hostname(),
+	}
+	dnsctx, cancel := context.WithTimeout(ctx, time.Duration(params.Timeout)*time.Second)
+	ipaddresses, err := lib.ResolveName(dnsctx, URL.Hostname())
+	cancel()
+	output.DNSLookup.TimeTaken = time.Since(istart).Microseconds()
+	if err != nil {
+		output.Error = err.Error()
+		output.DNSLookup.Error = err.Error()
+		if verbose {
 			fmt.Printf("%s ", lib.LogWithTimestamp(err.Error(), true))
-		} else {
+		}
+	} else {
+		output.DNSLookup.Success = true
+		output.DNSLookup.ResolvedAddresses = ipaddresses
+		if verbose {
 			fmt.Println(lib.LogWithTimestamp("DNS lookup successful for "+URL.Hostname()+"' to "+strconv.Itoa(len(ipaddresses))+" addresses '["+strings.Join(ipaddresses[:], ", ")+"]' in "+time.Since(istart).String(), false))
 		}
 	}
 
-	if *jsonoutput {
-		output.InputParams = lib.InputParams{
-			Mode:     "web",
-			Host:     URL.Host,
-			Protocol: "tcp",
-			Timeout:  timeout,
-			Count:    iterations,
-			Delay:    delay,
-			Payload:  len(data) + len(headers),
-			Throttle: *throttle,
-			Method:   method,
-			Data:     data,
-			Headers:  headers,
+	output.InputParams.FromPort, _ = strconv.Atoi(URL.Port())
+	if output.InputParams.FromPort == 0 {
+		if URL.Scheme == "https" {
+			output.InputParams.FromPort = 443
+		} else {
+			output.InputParams.FromPort = 80
 		}
-		output.ModuleName = "web"
-		output.InputParams.Host = URL.Host
-		resolvedIPs, err := lib.ResolveNameToIPs(context.Background(), URL.Hostname())
+	}
+	output.InputParams.ToPort = output.InputParams.FromPort
+	stats := make([]lib.WebStats, 0)
+
+	bearer := params.Bearer
+	if params.OAuth2TokenURL != "" { // the token is fetched once and used by every request
+		client := &http.Client{Timeout: time.Duration(params.Timeout) * time.Second, Transport: webTransport("")}
+		token, err := lib.FetchOAuth2Token(ctx, client, params)
+		client.CloseIdleConnections()
 		if err != nil {
-			output.DNSLookup = lib.DNSLookup{
-				Hostname: URL.Hostname(),
-			}
 			output.Error = err.Error()
-			output.DNSLookup.Success = false
-			output.DNSLookup.ResolvedAddresses = nil
-			output.DNSLookup.TimeTaken = time.Since(istart).Microseconds()
-
-		} else {
-			output.DNSLookup = lib.DNSLookup{
-				Hostname: URL.Hostname(),
-			}
-			output.DNSLookup.Success = true
-			output.DNSLookup.ResolvedAddresses = make([]string, len(resolvedIPs))
-			output.DNSLookup.TimeTaken = time.Since(istart).Microseconds()
-			for i, ip := range resolvedIPs {
-				output.DNSLookup.ResolvedAddresses[i] = ip.String()
-			}
+			output.EndTime = time.Now().UnixMicro()
+			output.TotalTimeTaken = output.EndTime - output.StartTime
+			return output
 		}
-
-		output.InputParams.FromPort, _ = strconv.Atoi(URL.Port())
-		if output.InputParams.FromPort == 0 {
-			if URL.Scheme == "https" {
-				output.InputParams.FromPort = 443
-			} else {
-				output.InputParams.FromPort = 80
-			}
+		bearer = token.AccessToken
+		if verbose {
+			fmt.Println(lib.LogWithTimestamp("OAuth2 token fetched from "+params.OAuth2TokenURL+", expires in "+(time.Duration(token.ExpiresIn)*time.Second).String(), false))
 		}
-		output.InputParams.ToPort = output.InputParams.FromPort
-		output.StartTime = istart.UnixMicro()
-		output.Stats = make([]lib.WebStats, 0)
 	}
 
+	// With reuse the requests share one transport and are sent one after
+	// another, so that each finds the connection of the previous one idle.
+	var shared http.RoundTripper
+	if params.Reuse {
+		shared = webTransport(params.HTTPVersion)
+		defer closeTransport(shared)
+	}
 	var WG sync.WaitGroup
-	for i := 0; i < iterations; i++ {
-		if *throttle { // check if throttle is enable, then slow things down a bit of random milisecond wait between 0 1000 ms
-			i, err := rand.Int(rand.Reader, big.NewInt(10000))
+	for i := 0; i < params.Count; i++ {
+		if params.Throttle { // check if throttle is enable, then slow things down a bit of random milisecond wait between 0 10000 ms
+			wait, err := lib.ThrottleDelay()
 			if err != nil {
-				if !*jsonoutput {
-					fmt.Println(err)
-					return // added return to exit if error occurs
-				} else {
-					output.Error = err.Error()
-					return
-				}
+				output.Error = err.Error()
+				break
 			}
-			time.Sleep(time.Millisecond * time.Duration(i.Int64()))
+			lib.Sleep(ctx, wait)
+		}
+		if ctx.Err() != nil { // interrupted, stop scheduling and let the in-flight requests finish
+			break
 		}
 		WG.Add(1)
-		go func(URL *url.URL) {
+		go func(URL *url.URL, iteration int) {
 			defer WG.Done()
 			errors := make([]string, 0)
 
+			transport := shared
+			if transport == nil {
+				transport = webTransport(params.HTTPVersion)
+				defer closeTransport(transport)
+			}
 			client := &http.Client{
-				Timeout: time.Duration(time.Duration(timeout) * time.Second),
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: false, MinVersion: tls.VersionTLS12},
-				},
-			} // setup http transport not to validate the SSL certificate
+				Timeout:   time.Duration(time.Duration(params.Timeout) * time.Second),
+				Transport: transport,
+			}
 
+			stat := lib.WebStats{URL: URL.String()}
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) { stat.Reused = info.Reused },
+			}
 			// Create a new request with the specified method, URL, and data
-			request, err := http.NewRequest(method, URL.String(), strings.NewReader(data))
+			request, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.WithoutCancel(ctx), trace), method, URL.String(), bytes.NewReader(body))
 			if err != nil {
-				if strings.Contains(err.Error(), "tls") {
+				if verbose && strings.Contains(err.Error(), "tls") {
 					fmt.Println(lib.LogWithTimestamp(err.Error(), true))
-					return
-				} else {
-					return
 				}
+				return
 			}
 			request.Header.Set("user-agent", HTTP_CLIENT_USER_AGENT) // set the header for the user-agent
 			// Set headers
@@ -136,67 +242,127 @@ func WebHandler(jsonoutput *bool, iterations int, delay int, throttle *bool, tim
 					errors = append(errors, "Invalid header format: "+fmt.Sprint(parts))
 				}
 			}
+			if contentType != "" && request.Header.Get("content-type") == "" {
+				request.Header.Set("content-type", contentType)
+			}
+			if user, password, _ := strings.Cut(params.User, ":"); params.User != "" && !params.Digest {
+				request.SetBasicAuth(user, password)
+			}
+			if bearer != "" {
+				request.Header.Set("authorization", "Bearer "+bearer)
+			}
 
+			stat.Request = map[string]any{"method": method, "body": request.Body, "headers": lib.RedactHeader(request.Header), "size_bytes": len(body), "sha256": hash}
 			start := time.Now() // capture initial time
+			stat.SentTime = start.UnixMicro()
 			response, err := client.Do(request)
+			if err == nil && params.Digest && response.StatusCode == http.StatusUnauthorized {
+				request, response, err = answerDigest(client, request, response, body, params.User)
+				stat.Request["headers"] = lib.RedactHeader(request.Header)
+			}
 			if err != nil {
-				fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+				if verbose {
+					fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+				}
+				stat.TimeTaken = time.Since(start).Microseconds()
+				stat.Errors = append(errors, err.Error())
+				MUTEX.Lock()
+				stats = append(stats, stat)
+				MUTEX.Unlock()
 				return
 			}
 			defer response.Body.Close()
-			body, _ := io.ReadAll(response.Body) // read the entire body, this should consume most of the time
+			body, truncated, _ := lib.ReadBody(response.Body, params.MaxBody) // read the body, this should consume most of the time
 			header := response.Header
 			time_taken := time.Since(start) //capture the time taken
 
+			stat.Response = map[string]any{"header": header}
+			if truncated {
+				stat.Response["body_truncated"] = true
+			}
+			if params.WithBody {
+				if err := lib.EmbedResponseBody(stat.Response, body, header.Get("content-type")); err != nil {
+					errors = append(errors, "JSON parse error: "+err.Error())
+				}
+			}
+			if path := params.BodyPath(iteration, header.Get("content-type")); path != "" {
+				if err := saveBody(path, body); err != nil {
+					errors = append(errors, err.Error())
+				} else {
+					stat.BodyFile = path
+				}
+			}
+			stat.Success = true
+			stat.StatusCode = response.StatusCode
+			stat.Protocol = response.Proto
+			if response.TLS != nil {
+				stat.ALPN = response.TLS.NegotiatedProtocol
+			}
+			stat.BytesDownloaded = len(body) + len(header)
+			stat.RecvTime = start.Add(time_taken).UnixMicro()
+			stat.TimeTaken = time_taken.Microseconds()
+			stat.Errors = errors
 			MUTEX.Lock()
-			stats = append(stats, time_taken)
+			stats = append(stats, stat)
 			MUTEX.Unlock()
-
-			if *jsonoutput {
-				stat := lib.WebStats{}
-
-				stat.URL = URL.String()
-				if includeresponsebody {
-					var jsondata interface{}
-					err = json.Unmarshal(body, &jsondata)
-					if err != nil {
-						errors = append(errors, "JSON parse error: "+fmt.Sprint(err.Error()))
-					}
-					stat.Response = map[string]any{"body": jsondata, "header": header}
-				} else {
-					stat.Response = map[string]any{"header": header}
+			if verbose {
+				fmt.Println(lib.LogWithTimestamp("Response: "+response.Status+", protocol: "+response.Proto+", bytes downloaded: "+strconv.Itoa(len(string(body)))+", speed: "+strconv.FormatFloat((float64(len(string(body)))/float64(time_taken.Seconds())/1024), 'G', -1, 64)+"KB/s, time taken: "+time_taken.String(), false))
+				if stat.BodyFile != "" {
+					fmt.Println(lib.LogWithTimestamp("Response body saved to "+stat.BodyFile, false))
 				}
-				stat.Request = map[string]any{"method": method, "body": request.Body, "headers": request.Header}
-				stat.Success = true
-				stat.StatusCode = response.StatusCode
-				stat.BytesDownloaded = len(body) + len(header)
-				stat.SentTime = start.UnixMicro()
-				stat.RecvTime = time.Now().UnixMicro()
-				stat.TimeTaken = stat.RecvTime - stat.SentTime
-				stat.Errors = errors
-				output.Stats = append(output.Stats.([]lib.WebStats), stat)
-			} else {
-				fmt.Println(lib.LogWithTimestamp("Response: "+response.Status+", bytes downloaded: "+strconv.Itoa(len(string(body)))+", speed: "+strconv.FormatFloat((float64(len(string(body)))/float64(time_taken.Seconds())/1024), 'G', -1, 64)+"KB/s, time taken: "+time_taken.String(), false))
-			}
-		}(URL)
+			}
+		}(URL, i+1)
+		if params.Reuse {
+			WG.Wait()
+		}
 	}
 	WG.Wait()
-	if *jsonoutput {
-		output.InputParams.Headers = headers
-		output.EndTime = time.Now().UnixMicro()
-		output.TotalTimeTaken = output.EndTime - output.StartTime
-		output.Error = ""
-		JS, jsonErr := json.MarshalIndent(output, "", "  ")
-		if jsonErr != nil {
-			fmt.Println(lib.LogWithTimestamp(jsonErr.Error(), true))
-			os.Exit(1)
-		}
-		fmt.Println(string(JS))
-	} else {
-		MUTEX.RLock()
-		fmt.Println(lib.LogStats("web", stats, iterations))
-		MUTEX.RUnlock()
-		fmt.Println("Total time taken: " + time.Since(istart).String())
+	output.Stats = stats
+	if params.Reuse {
+		reuse := lib.AnalyzeReuse(stats)
+		output.Reuse = &reuse
+	}
+	output.Cancelled = ctx.Err() == context.Canceled
+	output.EndTime = time.Now().UnixMicro()
+	output.TotalTimeTaken = output.EndTime - output.StartTime
+	return output
+}
+
+// answerDigest sends request again with the answer to the digest challenge of
+// its response, the time of both exchanges counting as the one of the request.
+func answerDigest(client *http.Client, request *http.Request, response *http.Response, body []byte, credentials string) (*http.Request, *http.Response, error) {
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	user, password, _ := strings.Cut(credentials, ":")
+	authorization, err := lib.DigestAuthorization(response.Header.Get("www-authenticate"), request.Method, request.URL.RequestURI(), user, password)
+	if err != nil {
+		return request, nil, err
+	}
+	retry := request.Clone(request.Context())
+	retry.Body = http.NoBody
+	if len(body) > 0 {
+		retry.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	retry.Header.Set("authorization", authorization)
+	response, err = client.Do(retry)
+	return retry, response, err
+}
+
+// saveBody writes a response body to path, creating its directory.
+func saveBody(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0o644)
+}
+
+// closeTransport releases the connections kept alive by transport, and the
+// UDP socket of HTTP/3 transports.
+func closeTransport(transport http.RoundTripper) {
+	if closer, ok := transport.(io.Closer); ok {
+		closer.Close()
+	} else if idle, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		idle.CloseIdleConnections()
 	}
 }
 
@@ -2,15 +2,27 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/dmartsapp/shint/lib"
+	"github.com/quic-go/quic-go/http3"
 )
 
 // TestWebHandler tests the WebHandler function.
@@ -63,7 +75,7 @@ func TestWebHandler(t *testing.T) {
 	headers := []string{"X-Test-Header: TestValue"}
 
 	// Run the handler
-	WebHandler(&jsonOutput, iterations, delay, &throttle, timeout, serverURL, method, data, headers, includeBody)
+	WebHandler(t.Context(), &jsonOutput, iterations, delay, &throttle, timeout, serverURL, method, data, headers, includeBody)
 
 	// Restore stdout and read captured output
 	_ = w.Close()
@@ -73,13 +85,6 @@ func TestWebHandler(t *testing.T) {
 	
 	// --- Validate the output ---
 	outputStr := buf.String()
-	if !strings.Contains(outputStr, `"status_code":200`) {
-		t.Errorf("Expected status code 200 in output, got:\n%s", outputStr)
-	}
-
-	if !strings.Contains(outputStr, `"method":"POST"`) {
-		t.Errorf("Expected method POST in JSON output, got:\n%s", outputStr)
-	}
 
 	// Unmarshal to inspect JSON details
 	var result map[string]interface{}
@@ -95,6 +100,14 @@ func TestWebHandler(t *testing.T) {
 	if !ok {
 		t.Fatal("Could not parse first stat entry")
 	}
+	if firstStat["status_code"] != float64(200) {
+		t.Errorf("Expected status code 200 in output, got:\n%s", outputStr)
+	}
+
+	request, ok := firstStat["request"].(map[string]interface{})
+	if !ok || request["method"] != "POST" {
+		t.Errorf("Expected method POST in JSON output, got:\n%s", outputStr)
+	}
 	response, ok := firstStat["response"].(map[string]interface{})
 	if !ok {
 		t.Fatal("No response object in stats")
@@ -108,4 +121,264 @@ func TestWebHandler(t *testing.T) {
 	}
 
 	log.Println("WebHandler unit test passed.")
-}
\ No newline at end of file
+}
+
+// TestWebHTTPVersions requests local HTTP/1.1, HTTP/2, h2c and HTTP/3 servers
+// and checks the protocol and ALPN recorded for each HTTP version.
+func TestWebHTTPVersions(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	secure := httptest.NewUnstartedServer(handler)
+	secure.EnableHTTP2 = true
+	secure.StartTLS()
+	defer secure.Close()
+	plain := httptest.NewUnstartedServer(handler)
+	plain.Config.Protocols = new(http.Protocols)
+	plain.Config.Protocols.SetHTTP1(true)
+	plain.Config.Protocols.SetUnencryptedHTTP2(true)
+	plain.Start()
+	defer plain.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quic := &http3.Server{Handler: handler, TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: secure.TLS.Certificates})}
+	go quic.Serve(conn)
+	defer quic.Close()
+	quicURL := "https://127.0.0.1:" + strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+
+	webRootCAs = x509.NewCertPool()
+	webRootCAs.AddCert(secure.Certificate())
+	defer func() { webRootCAs = nil }()
+
+	for _, test := range []struct {
+		url, version, protocol, alpn string
+	}{
+		{secure.URL, "", "HTTP/2.0", "h2"},
+		{secure.URL, lib.HTTP11, "HTTP/1.1", ""},
+		{secure.URL, lib.HTTP2, "HTTP/2.0", "h2"},
+		{plain.URL, "", "HTTP/1.1", ""},
+		{plain.URL, lib.H2C, "HTTP/2.0", ""},
+		{quicURL, lib.HTTP3, "HTTP/3.0", "h3"},
+	} {
+		params := lib.InputParams{Mode: "web", URL: test.url, HTTPVersion: test.version}
+		if err := params.Normalize(); err != nil {
+			t.Fatal(err)
+		}
+		output := Probe(t.Context(), params)
+		stats := output.Stats.([]lib.WebStats)
+		if len(stats) != 1 || !stats[0].Success {
+			t.Fatalf("Expected a successful request to %s with version '%s', got %+v", test.url, test.version, stats)
+		}
+		if stats[0].Protocol != test.protocol || stats[0].ALPN != test.alpn || stats[0].Reused {
+			t.Errorf("Expected %s with ALPN '%s' on a new connection for version '%s', got %s with ALPN '%s', reused %t", test.protocol, test.alpn, test.version, stats[0].Protocol, stats[0].ALPN, stats[0].Reused)
+		}
+	}
+
+	params := lib.InputParams{Mode: "web", URL: plain.URL, HTTPVersion: lib.HTTP3}
+	if err := params.Normalize(); err == nil {
+		t.Error("Expected HTTP/3 to be refused for an http:// URL")
+	}
+}
+
+// TestWebReuse sends requests over shared HTTP/1.1 and HTTP/2 connections and
+// checks that only the first one is cold, and that without reuse every
+// request opens its own connection.
+func TestWebReuse(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	webRootCAs = x509.NewCertPool()
+	webRootCAs.AddCert(server.Certificate())
+	defer func() { webRootCAs = nil }()
+
+	for _, test := range []struct {
+		version    string
+		reuse      bool
+		cold, warm int
+	}{
+		{lib.HTTP11, true, 1, 3},
+		{lib.HTTP2, true, 1, 3},
+		{lib.HTTP11, false, 4, 0},
+	} {
+		params := lib.InputParams{Mode: "web", URL: server.URL, HTTPVersion: test.version, Reuse: test.reuse, Count: 4}
+		if err := params.Normalize(); err != nil {
+			t.Fatal(err)
+		}
+		output := Probe(t.Context(), params)
+		reuse := lib.AnalyzeReuse(output.Stats.([]lib.WebStats))
+		if reuse.Cold.Requests != test.cold || reuse.Warm.Requests != test.warm {
+			t.Errorf("Expected %d cold and %d warm requests over HTTP/%s with reuse %t, got %+v", test.cold, test.warm, test.version, test.reuse, reuse)
+		}
+		if (output.Reuse != nil) != test.reuse {
+			t.Errorf("Expected the reuse analysis in the output only with reuse, got %+v", output.Reuse)
+		}
+	}
+}
+
+// TestWebRequestBody posts a urlencoded form and checks that the content type
+// is set for it and that the size and hash of the body are recorded.
+func TestWebRequestBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" || r.FormValue("name") != "test" {
+			t.Errorf("Expected a urlencoded form with name=test, got %s with %v", r.Header.Get("Content-Type"), r.Form)
+		}
+	}))
+	defer server.Close()
+	params := lib.InputParams{Mode: "web", URL: server.URL, Method: http.MethodPost, Form: []string{"name=test"}}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	stats := Probe(t.Context(), params).Stats.([]lib.WebStats)
+	if len(stats) != 1 || !stats[0].Success {
+		t.Fatalf("Expected a successful request, got %+v", stats)
+	}
+	// sha256 of "name=test"
+	if stats[0].Request["size_bytes"] != 9 || stats[0].Request["sha256"] != "12f10810f1572a97e398bf247a60c1d4c9097b9782b18e9d4dc5f65de6fba230" {
+		t.Errorf("Expected the size and hash of the body in the request, got %v and %v", stats[0].Request["size_bytes"], stats[0].Request["sha256"])
+	}
+
+	params.Data = "raw"
+	if err := params.Normalize(); err == nil {
+		t.Error("Expected data and form together to be refused")
+	}
+}
+
+// TestWebSaveBody saves the capped HTML bodies of two iterations and checks
+// that they are embedded as text.
+func TestWebSaveBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<p>hello world</p>"))
+	}))
+	defer server.Close()
+	params := lib.InputParams{Mode: "web", URL: server.URL, Count: 2, WithBody: true, OutputDir: t.TempDir(), MaxBody: 5}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	stats := Probe(t.Context(), params).Stats.([]lib.WebStats)
+	files := make(map[string]bool)
+	for _, stat := range stats {
+		if stat.Response["body"] != "<p>he" || stat.Response["body_truncated"] != true || len(stat.Errors) > 0 {
+			t.Errorf("Expected the first 5 bytes of the body as text, got %v (%v)", stat.Response, stat.Errors)
+		}
+		saved, err := os.ReadFile(stat.BodyFile)
+		if err != nil || string(saved) != "<p>he" {
+			t.Errorf("Expected the capped body in %s, got '%s' (%v)", stat.BodyFile, saved, err)
+		}
+		files[stat.BodyFile] = true
+	}
+	if len(stats) != 2 || len(files) != 2 {
+		t.Errorf("Expected one file per iteration, got %v", files)
+	}
+}
+
+// TestWebAuth authenticates with basic, digest and OAuth2 credentials against
+// local servers, checking that the token is fetched once and that no
+// credential shows in the output.
+func TestWebAuth(t *testing.T) {
+	tokens := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "client" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "read write" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tokens++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"t0ken","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if user, password, ok := r.BasicAuth(); ok && user == "admin" && password == "s3cret" || authorization == "Bearer t0ken" {
+			return
+		}
+		if strings.HasPrefix(authorization, "Digest ") {
+			// the answer of the client is checked by computing it again
+			fields := map[string]string{}
+			for _, field := range strings.Split(strings.TrimPrefix(authorization, "Digest "), ", ") {
+				name, value, _ := strings.Cut(field, "=")
+				fields[name] = strings.Trim(value, `"`)
+			}
+			hash := func(value string) string {
+				sum := md5.Sum([]byte(value))
+				return hex.EncodeToString(sum[:])
+			}
+			response := hash(hash("admin:shint:s3cret") + ":n0nce:" + fields["nc"] + ":" + fields["cnonce"] + ":auth:" + hash(r.Method+":"+fields["uri"]))
+			if fields["cnonce"] != "" && fields["uri"] == r.URL.RequestURI() && fields["response"] == response {
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Digest realm="shint", nonce="n0nce", qop="auth"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	for _, params := range []lib.InputParams{
+		{Mode: "web", URL: server.URL + "/basic", User: "admin:s3cret"},
+		{Mode: "web", URL: server.URL + "/digest?x=1", User: "admin:s3cret", Digest: true, Method: http.MethodPost, Data: `{"a":1}`},
+		{Mode: "web", URL: server.URL + "/oauth2", Count: 3, OAuth2TokenURL: tokenServer.URL, OAuth2ClientID: "client", OAuth2ClientSecret: "s3cret", OAuth2Scopes: []string{"read", "write"}},
+	} {
+		if err := params.Normalize(); err != nil {
+			t.Fatal(err)
+		}
+		output := Probe(t.Context(), params)
+		stats, _ := output.Stats.([]lib.WebStats)
+		if len(stats) != params.Count {
+			t.Fatalf("Expected %d requests to %s, got %+v (%s)", params.Count, params.URL, stats, output.Error)
+		}
+		for _, stat := range stats {
+			if stat.StatusCode != http.StatusOK {
+				t.Errorf("Expected %s to authenticate, got status %d", params.URL, stat.StatusCode)
+			}
+		}
+		data, _ := json.Marshal(output)
+		if strings.Contains(string(data), "s3cret") || strings.Contains(string(data), "t0ken") || strings.Contains(string(data), "Basic ") {
+			t.Errorf("Expected the credentials to be redacted, got %s", data)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("Expected the OAuth2 token to be fetched once, got %d", tokens)
+	}
+}
+
+// TestWebInterrupted cancels a run of requests sent one after another while
+// the second one is served, and checks that it completes and is the last.
+func TestWebInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	var served atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1) == 2 {
+			cancel()
+			time.Sleep(50 * time.Millisecond) // answer after the cancellation
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	params := lib.InputParams{Mode: "web", URL: server.URL, Reuse: true, Count: 20, Timeout: 2}
+	if err := params.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	output := runWeb(ctx, params, false)
+	stats := output.Stats.([]lib.WebStats)
+	if !output.Cancelled || len(stats) != 2 {
+		t.Fatalf("Expected a cancelled run with 2 of the %d requests, got %d in %+v", params.Count, len(stats), output)
+	}
+	for _, stat := range stats {
+		if !stat.Success || stat.StatusCode != http.StatusOK {
+			t.Errorf("Expected the request in flight to complete, got %+v", stat)
+		}
+	}
+	if output.Reuse == nil || output.Reuse.Cold.Requests+output.Reuse.Warm.Requests != 2 {
+		t.Errorf("Expected the reuse analysis of the 2 requests, got %+v", output.Reuse)
+	}
+}
@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistoryRecord is one line of the history file: the condensed figures used
+// for trends plus the complete output it was derived from.
+type HistoryRecord struct {
+	Time       int64      `json:"time_unixtime_µs"`
+	ModuleName string     `json:"module_name"`
+	Target     string     `json:"target"`
+	Sent       int        `json:"sent"`
+	Succeeded  int        `json:"succeeded"`
+	AvgLatency int64      `json:"avg_latency_µs"`
+	Result     JSONOutput `json:"result"`
+}
+
+// HistoryDay aggregates the records of one module and target for a calendar day.
+type HistoryDay struct {
+	Day         string  `json:"day"`
+	Runs        int     `json:"runs"`
+	Sent        int     `json:"sent"`
+	Succeeded   int     `json:"succeeded"`
+	SuccessRate float64 `json:"success_rate"`
+	AvgLatency  int64   `json:"avg_latency_µs"`
+}
+
+var historyMutex sync.Mutex
+
+// DataDir returns the per user directory shint keeps its data in, following
+// XDG on unix like systems.
+func DataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "shint")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "shint"
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, "shint")
+		}
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "shint")
+	}
+	return filepath.Join(home, ".local", "share", "shint")
+}
+
+func DefaultHistoryPath() string {
+	return filepath.Join(DataDir(), "history.jsonl")
+}
+
+// AppendHistory adds output to the history file at path, creating it if needed.
+func AppendHistory(path string, output JSONOutput) error {
+	summary := Summarize(output)
+	record := HistoryRecord{
+		Time:       output.EndTime,
+		ModuleName: output.ModuleName,
+		Target:     output.InputParams.Target(),
+		Sent:       summary.Sent,
+		Succeeded:  summary.Succeeded,
+		Result:     output,
+	}
+	if record.Time == 0 {
+		record.Time = time.Now().UnixMicro()
+	}
+	if len(summary.Latencies) > 0 {
+		_, avg, _ := GetMinAvgMax(summary.Latencies)
+		record.AvgLatency = avg.Microseconds()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// ReadHistory returns the records of path whose target or host is target
+// (any target when empty), optionally restricted to one module, recorded
+// at or after since. Lines that cannot be parsed are skipped.
+func ReadHistory(path string, target string, module string, since time.Time) ([]HistoryRecord, error) {
+	records := make([]HistoryRecord, 0)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	} else if err != nil {
+		return records, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record HistoryRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		if record.Time < since.UnixMicro() {
+			continue
+		}
+		if module != "" && record.ModuleName != module && !(module == "ping" && record.ModuleName == "icmp") {
+			continue
+		}
+		if target != "" && record.Target != target && record.Result.InputParams.Host != target {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Trend groups records into one HistoryDay per local calendar day, oldest
+// first, covering every day from the first to the last record.
+func Trend(records []HistoryRecord) []HistoryDay {
+	days := make([]HistoryDay, 0)
+	if len(records) == 0 {
+		return days
+	}
+	index := make(map[string]int)
+	latencies := make(map[string][]time.Duration)
+	first, last := time.UnixMicro(records[0].Time), time.UnixMicro(records[0].Time)
+	for _, record := range records {
+		at := time.UnixMicro(record.Time)
+		if at.Before(first) {
+			first = at
+		}
+		if at.After(last) {
+			last = at
+		}
+	}
+	midnight := func(at time.Time) time.Time {
+		return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	}
+	for day := midnight(first); !day.After(midnight(last)); day = day.AddDate(0, 0, 1) {
+		index[day.Format(time.DateOnly)] = len(days)
+		days = append(days, HistoryDay{Day: day.Format(time.DateOnly)})
+	}
+	for _, record := range records {
+		name := time.UnixMicro(record.Time).Format(time.DateOnly)
+		day := &days[index[name]]
+		day.Runs++
+		day.Sent += record.Sent
+		day.Succeeded += record.Succeeded
+		if record.Succeeded > 0 {
+			latencies[name] = append(latencies[name], time.Duration(record.AvgLatency)*time.Microsecond)
+		}
+	}
+	for i := range days {
+		if days[i].Sent > 0 {
+			days[i].SuccessRate = float64(days[i].Succeeded) * 100 / float64(days[i].Sent)
+		}
+		if len(latencies[days[i].Day]) > 0 {
+			_, avg, _ := GetMinAvgMax(latencies[days[i].Day])
+			days[i].AvgLatency = avg.Microseconds()
+		}
+	}
+	return days
+}
+
+// Sparkline renders values as a row of block characters scaled between the
+// smallest and largest value. Negative values mark gaps and render as spaces.
+func Sparkline(values []float64) string {
+	min, max := -1.0, -1.0
+	for _, value := range values {
+		if value < 0 {
+			continue
+		}
+		if min < 0 || value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+	}
+	return SparklineBetween(values, min, max)
+}
+
+// SparklineBetween renders values like Sparkline on a fixed scale from min to max.
+func SparklineBetween(values []float64, min, max float64) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	var line strings.Builder
+	for _, value := range values {
+		switch {
+		case value < 0:
+			line.WriteRune(' ')
+		case max <= min:
+			line.WriteRune(blocks[len(blocks)/2])
+		default:
+			value = math.Min(math.Max(value, min), max)
+			line.WriteRune(blocks[int((value-min)/(max-min)*float64(len(blocks)-1)+0.5)])
+		}
+	}
+	return line.String()
+}
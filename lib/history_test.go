@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHistoryRoundTrip appends results to a new history file, corrupts one
+// line and reads back the records of one target.
+func TestHistoryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shint", "history.jsonl")
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	telnet := func(host string, at time.Time, success bool) JSONOutput {
+		return JSONOutput{
+			ModuleName:  "telnet",
+			EndTime:     at.UnixMicro(),
+			InputParams: InputParams{Mode: "telnet", Host: host, FromPort: 443},
+			Stats:       []TelnetStats{{Address: host, Success: success, TimeTaken: 2000}},
+		}
+	}
+	if err := AppendHistory(path, telnet("example.com", start, true)); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("{\"time_unixtime_µs\":\n")
+	file.Close()
+	for _, output := range []JSONOutput{telnet("example.org", start, true), telnet("example.com", start.Add(time.Hour), false)} {
+		if err := AppendHistory(path, output); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ReadHistory(path, "example.com", "telnet", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected the 2 records of example.com around the corrupt line, got %+v", records)
+	}
+	if records[0].Target != "example.com:443" || records[0].Succeeded != 1 || records[0].AvgLatency != 2000 || records[1].Succeeded != 0 {
+		t.Errorf("Unexpected records %+v", records)
+	}
+	if stats, ok := records[0].Result.Stats.([]TelnetStats); !ok || len(stats) != 1 {
+		t.Errorf("Expected the complete output in the record, got %+v", records[0].Result)
+	}
+	if records, _ := ReadHistory(path, "", "", start.Add(time.Minute)); len(records) != 1 {
+		t.Errorf("Expected 1 record since the last run, got %d", len(records))
+	}
+	if records, err := ReadHistory(filepath.Join(t.TempDir(), "missing.jsonl"), "", "", time.Time{}); err != nil || len(records) != 0 {
+		t.Errorf("Expected no records and no error for a missing file, got %d and %v", len(records), err)
+	}
+}
+
+// TestTrend buckets records by local day, leaving an empty day in between.
+func TestTrend(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	records := []HistoryRecord{
+		{Time: day.Add(23 * time.Hour).UnixMicro(), Sent: 4, Succeeded: 4, AvgLatency: 3000},
+		{Time: day.Add(time.Hour).UnixMicro(), Sent: 4, Succeeded: 2, AvgLatency: 1000},
+		{Time: day.AddDate(0, 0, 2).UnixMicro(), Sent: 2, Succeeded: 0},
+	}
+	days := Trend(records)
+	if len(days) != 3 {
+		t.Fatalf("Expected 3 days, got %+v", days)
+	}
+	want := []HistoryDay{
+		{Day: "2024-03-01", Runs: 2, Sent: 8, Succeeded: 6, SuccessRate: 75, AvgLatency: 2000},
+		{Day: "2024-03-02"},
+		{Day: "2024-03-03", Runs: 1, Sent: 2},
+	}
+	for i := range want {
+		if days[i] != want[i] {
+			t.Errorf("Day %d: expected %+v, got %+v", i, want[i], days[i])
+		}
+	}
+	if days := Trend(nil); len(days) != 0 {
+		t.Errorf("Expected no days without records, got %+v", days)
+	}
+}
+
+// TestSparkline scales values between their extremes, draws a constant series
+// at mid height and gaps as spaces.
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   string
+	}{
+		{nil, ""},
+		{[]float64{}, ""},
+		{[]float64{5, 5, 5}, "▅▅▅"},
+		{[]float64{0, -1, 7}, "▁ █"},
+		{[]float64{-1, -1}, "  "},
+	}
+	for _, test := range tests {
+		if got := Sparkline(test.values); got != test.want {
+			t.Errorf("Sparkline(%v): expected %q, got %q", test.values, test.want, got)
+		}
+	}
+}
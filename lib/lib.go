@@ -2,6 +2,8 @@ package lib
 
 import (
 	"context"
+	"crypto/rand"
+	"math/big"
 	"net"
 	"slices"
 	"sort"
@@ -64,3 +66,32 @@ func ConvertIPToStringSlice(ips []net.IP) []string {
 	}
 	return result
 }
+
+// Sleep waits for duration unless ctx is done first, and reports whether the
+// full duration elapsed. It is used between iterations so that an interrupt
+// stops scheduling new probes straight away.
+func Sleep(ctx context.Context, duration time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if duration <= 0 {
+		return true
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// ThrottleDelay returns the random wait between 0 and 10000 ms used by --throttle.
+func ThrottleDelay() (time.Duration, error) {
+	in, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return 0, err
+	}
+	return time.Millisecond * time.Duration(in.Int64()), nil
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	MonitorEventUp            string = "up"
+	MonitorEventDown          string = "down"
+	MonitorEventLatencyHigh   string = "latency_high"
+	MonitorEventLatencyNormal string = "latency_normal"
+	MonitorEventDNSChanged    string = "dns_changed"
+)
+
+type MonitorEvent struct {
+	Time              int64    `json:"time_unixtime_µs"`
+	ModuleName        string   `json:"module_name"`
+	Target            string   `json:"target"`
+	Event             string   `json:"event"`
+	Message           string   `json:"message"`
+	OutageDuration    int64    `json:"outage_duration_µs"`
+	AverageLatency    int64    `json:"average_latency_µs"`
+	ResolvedAddresses []string `json:"resolved_addresses"`
+}
+
+// Monitor tracks the state of a single target across repeated probe rounds and
+// reports only the rounds where something changed. Latency is judged on the
+// average of the last Window successful TimeTaken values so that a single slow
+// reply does not flap the state.
+type Monitor struct {
+	Window    int
+	Threshold time.Duration // zero disables latency events
+
+	latencies []time.Duration
+	up        bool
+	observed  bool
+	downSince time.Time
+	slow      bool
+	addresses []string
+}
+
+func NewMonitor(window int, threshold time.Duration) *Monitor {
+	if window < 1 {
+		window = 1
+	}
+	return &Monitor{Window: window, Threshold: threshold, latencies: make([]time.Duration, 0, window)}
+}
+
+// Observe feeds the result of one probe round into the monitor and returns the
+// events it caused, if any. The first round always reports the initial state.
+func (monitor *Monitor) Observe(at time.Time, output JSONOutput) []MonitorEvent {
+	events := make([]MonitorEvent, 0)
+	summary := Summarize(output)
+	target := output.InputParams.Target()
+	event := func(name, message string) MonitorEvent {
+		return MonitorEvent{
+			Time:              at.UnixMicro(),
+			ModuleName:        output.ModuleName,
+			Target:            target,
+			Event:             name,
+			Message:           message,
+			AverageLatency:    monitor.Average().Microseconds(),
+			ResolvedAddresses: output.DNSLookup.ResolvedAddresses,
+		}
+	}
+
+	if output.DNSLookup.Success {
+		addresses := slices.Clone(output.DNSLookup.ResolvedAddresses)
+		slices.Sort(addresses)
+		if monitor.addresses != nil && !slices.Equal(addresses, monitor.addresses) {
+			events = append(events, event(MonitorEventDNSChanged, "DNS answer for "+output.DNSLookup.Hostname+" changed from ["+strings.Join(monitor.addresses, ", ")+"] to ["+strings.Join(addresses, ", ")+"]"))
+		}
+		monitor.addresses = addresses
+	}
+
+	for _, latency := range summary.Latencies {
+		monitor.latencies = append(monitor.latencies, latency)
+		if len(monitor.latencies) > monitor.Window {
+			monitor.latencies = monitor.latencies[1:]
+		}
+	}
+
+	up := summary.Succeeded > 0
+	switch {
+	case !monitor.observed && up:
+		events = append(events, event(MonitorEventUp, target+" is UP"))
+	case !monitor.observed && !up:
+		monitor.downSince = at
+		events = append(events, event(MonitorEventDown, target+" is DOWN"+reason(output)))
+	case monitor.up && !up:
+		monitor.downSince = at
+		events = append(events, event(MonitorEventDown, target+" went DOWN"+reason(output)))
+	case !monitor.up && up:
+		outage := at.Sub(monitor.downSince)
+		recovered := event(MonitorEventUp, target+" is back UP after an outage of "+outage.Round(time.Millisecond).String())
+		recovered.OutageDuration = outage.Microseconds()
+		events = append(events, recovered)
+	}
+	monitor.up = up
+	monitor.observed = true
+
+	if monitor.Threshold > 0 && up {
+		average := monitor.Average()
+		if !monitor.slow && average > monitor.Threshold {
+			monitor.slow = true
+			events = append(events, event(MonitorEventLatencyHigh, target+" latency average "+average.String()+" is above threshold "+monitor.Threshold.String()))
+		} else if monitor.slow && average <= monitor.Threshold {
+			monitor.slow = false
+			events = append(events, event(MonitorEventLatencyNormal, target+" latency average "+average.String()+" is back below threshold "+monitor.Threshold.String()))
+		}
+	}
+	return events
+}
+
+// Average returns the mean of the latencies currently held in the window.
+func (monitor *Monitor) Average() time.Duration {
+	if len(monitor.latencies) == 0 {
+		return 0
+	}
+	_, avg, _ := GetMinAvgMax(monitor.latencies)
+	return avg
+}
+
+// reason picks the most useful error message from a failed round.
+func reason(output JSONOutput) string {
+	if output.Error != "" {
+		return ": " + output.Error
+	}
+	if stats, ok := output.Stats.([]WebStats); ok {
+		for _, stat := range stats {
+			if len(stat.Errors) > 0 {
+				return ": " + stat.Errors[len(stat.Errors)-1]
+			}
+		}
+	}
+	return ""
+}
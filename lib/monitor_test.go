@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func telnetRound(success bool, latency time.Duration, addresses ...string) JSONOutput {
+	return JSONOutput{
+		InputParams: InputParams{Mode: "telnet", Host: "example.com", FromPort: 443},
+		ModuleName:  "telnet",
+		DNSLookup:   DNSLookup{Hostname: "example.com", Success: true, ResolvedAddresses: addresses},
+		Stats:       []TelnetStats{{Address: addresses[0], Success: success, TimeTaken: latency.Microseconds()}},
+	}
+}
+
+// TestMonitorTransitions checks that only state changes produce events.
+func TestMonitorTransitions(t *testing.T) {
+	monitor := NewMonitor(2, 100*time.Millisecond)
+	start := time.Now()
+	rounds := []struct {
+		output JSONOutput
+		events []string
+	}{
+		{telnetRound(true, 10*time.Millisecond, "10.0.0.1"), []string{MonitorEventUp}},
+		{telnetRound(true, 20*time.Millisecond, "10.0.0.1"), []string{}},
+		{telnetRound(false, 0, "10.0.0.1"), []string{MonitorEventDown}},
+		{telnetRound(false, 0, "10.0.0.1"), []string{}},
+		{telnetRound(true, 500*time.Millisecond, "10.0.0.1"), []string{MonitorEventUp, MonitorEventLatencyHigh}},
+		{telnetRound(true, 10*time.Millisecond, "10.0.0.2"), []string{MonitorEventDNSChanged}},
+		{telnetRound(true, 10*time.Millisecond, "10.0.0.2"), []string{MonitorEventLatencyNormal}},
+	}
+	for i, round := range rounds {
+		events := monitor.Observe(start.Add(time.Duration(i)*time.Second), round.output)
+		if len(events) != len(round.events) {
+			t.Fatalf("round %d: expected events %v, got %+v", i, round.events, events)
+		}
+		for j, event := range events {
+			if event.Event != round.events[j] {
+				t.Errorf("round %d: expected event %s, got %s", i, round.events[j], event.Event)
+			}
+		}
+		if i == 4 && events[0].OutageDuration != (2*time.Second).Microseconds() {
+			t.Errorf("expected an outage of 2s, got %dµs", events[0].OutageDuration)
+		}
+	}
+}
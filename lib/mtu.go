@@ -0,0 +1,211 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Results of an MTU probe, recorded in MTUProbe.Result.
+const (
+	MTUReply               = "reply"
+	MTUFragmentationNeeded = "fragmentation needed"
+	MTUMessageTooLong      = "message too long"
+	MTUUnreachable         = "unreachable"
+	MTUTimeout             = "timeout"
+)
+
+const (
+	minMTU       = 68 // every IPv4 link carries packets of this size (RFC 791)
+	echoOverhead = 28 // IPv4 header and ICMP echo header in front of the data
+)
+
+// MTUOptions controls a path MTU discovery.
+type MTUOptions struct {
+	MaxMTU   int           // largest packet size tried
+	Attempts int           // echo requests sent for a size before it is deemed not to pass
+	Timeout  time.Duration // how long a reply is waited for
+}
+
+// mtuAnswer is an ICMP message answering one of the echo requests.
+type mtuAnswer struct {
+	at         time.Time
+	from       string
+	seq        int
+	result     string
+	nextHopMTU int
+}
+
+// DiscoverMTU binary-searches the largest IPv4 packet that reaches
+// destination with the don't fragment bit set. Every size is probed with an
+// ICMP echo request; routers that cannot forward it answer with fragmentation
+// needed and usually the MTU of their next hop, which narrows the search down.
+// Sizes that are silently dropped, as by tunnels eating the ICMP errors, are
+// retried Attempts times before they count as too large. Setting the don't
+// fragment bit needs a raw ICMP socket, i.e. root or the CAP_NET_RAW
+// capability.
+func DiscoverMTU(ctx context.Context, destination net.IP, options MTUOptions) (MTUStats, error) {
+	destination = destination.To4()
+	if destination == nil {
+		return MTUStats{}, errors.New("mtu only supports IPv4 destinations")
+	}
+	config := net.ListenConfig{
+		Control: func(network, address string, raw syscall.RawConn) error {
+			var err error
+			if cerr := raw.Control(func(fd uintptr) { err = setDontFragment(fd) }); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	conn, err := config.ListenPacket(ctx, "ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return MTUStats{}, fmt.Errorf("mtu needs a raw ICMP socket with the don't fragment bit, run it as root or with the CAP_NET_RAW capability: %w", err)
+	}
+	defer conn.Close()
+	id := rand.IntN(0xffff)
+	answers, done := make(chan mtuAnswer, 16), make(chan struct{})
+	defer close(done)
+	go readMTUAnswers(conn, destination, id, answers, done)
+
+	stats := MTUStats{Address: destination.String(), Probes: make([]MTUProbe, 0)}
+	seq := 0
+	probe := func(size int) MTUProbe {
+		var result MTUProbe
+		for attempt := 0; attempt < max(options.Attempts, 1) && ctx.Err() == nil; attempt++ {
+			seq++
+			result = sendMTUProbe(ctx, conn, answers, destination, id, seq&0xffff, size, options.Timeout)
+			stats.Probes = append(stats.Probes, result)
+			if result.Result != MTUTimeout { // only silence is worth another try
+				break
+			}
+		}
+		return result
+	}
+
+	if first := probe(minMTU); !first.Success {
+		stats.FailedAt, stats.FailedBy, stats.FailReason = minMTU, first.From, "destination does not answer echo requests: "+first.Result
+		return stats, nil
+	}
+	low, high := minMTU, options.MaxMTU+1 // low passes, high does not
+	failure := probe(options.MaxMTU)
+	if failure.Success {
+		low = options.MaxMTU
+	} else {
+		high = options.MaxMTU
+	}
+	for high-low > 1 && ctx.Err() == nil {
+		if failure.NextHopMTU == low { // the router told the MTU and it was confirmed
+			high = low + 1
+			break
+		}
+		size := (low + high) / 2
+		if hint := failure.NextHopMTU; hint > low && hint < high { // try what the router said first
+			size = hint
+		}
+		if result := probe(size); result.Success {
+			low = size
+		} else {
+			high, failure = size, result
+		}
+	}
+	stats.Success = ctx.Err() == nil
+	stats.PathMTU, stats.MaxPayload = low, low-echoOverhead
+	if high <= options.MaxMTU {
+		stats.FailedAt, stats.FailedBy, stats.FailReason = high, failure.From, failure.Result
+	}
+	return stats, nil
+}
+
+// sendMTUProbe sends an echo request making an IP packet of size bytes and
+// waits for its answer.
+func sendMTUProbe(ctx context.Context, conn net.PacketConn, answers <-chan mtuAnswer, destination net.IP, id int, seq int, size int, timeout time.Duration) MTUProbe {
+	result := MTUProbe{Size: size, Result: MTUTimeout}
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: make([]byte, size-echoOverhead)},
+	}).Marshal(nil)
+	if err != nil {
+		result.Result = err.Error()
+		return result
+	}
+	sent := time.Now()
+	if _, err := conn.WriteTo(request, &net.IPAddr{IP: destination}); err != nil {
+		result.Result = err.Error()
+		if isMessageTooLong(err) { // larger than the MTU of the interface or one already learned
+			result.Result, result.From = MTUMessageTooLong, "local"
+		}
+		return result
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case answer, ok := <-answers:
+			if !ok {
+				return result
+			}
+			if answer.seq != seq { // late answer to an earlier probe
+				continue
+			}
+			result.Success = answer.result == MTUReply
+			result.Result, result.From, result.NextHopMTU = answer.result, answer.from, answer.nextHopMTU
+			result.TimeTaken = answer.at.Sub(sent).Microseconds()
+			return result
+		case <-timer.C:
+			return result
+		case <-ctx.Done():
+			return result
+		}
+	}
+}
+
+// readMTUAnswers reads the raw ICMP socket until it is closed and forwards
+// the echo replies and unreachables concerning the echo requests of id until
+// done is closed.
+func readMTUAnswers(conn net.PacketConn, destination net.IP, id int, answers chan<- mtuAnswer, done <-chan struct{}) {
+	buffer := make([]byte, 65536)
+	for {
+		n, peer, err := conn.ReadFrom(buffer)
+		if err != nil {
+			close(answers)
+			return
+		}
+		message := buffer[:n]
+		if len(message) < 8 {
+			continue
+		}
+		answer := mtuAnswer{at: time.Now(), from: peer.String()}
+		switch message[0] {
+		case byte(ipv4.ICMPTypeEchoReply):
+			if answer.from != destination.String() || int(binary.BigEndian.Uint16(message[4:6])) != id {
+				continue
+			}
+			answer.seq, answer.result = int(binary.BigEndian.Uint16(message[6:8])), MTUReply
+		case byte(ipv4.ICMPTypeDestinationUnreachable):
+			quoted, ok := parseQuoted(message[8:], answer.at, answer.from)
+			if !ok || quoted.proto != 1 || quoted.id != id || !quoted.target.Equal(destination) {
+				continue
+			}
+			answer.seq, answer.result = quoted.seq, MTUUnreachable
+			if message[1] == 4 { // fragmentation needed and DF set, with the next-hop MTU (RFC 1191)
+				answer.result, answer.nextHopMTU = MTUFragmentationNeeded, int(binary.BigEndian.Uint16(message[6:8]))
+			}
+		default:
+			continue
+		}
+		select {
+		case answers <- answer:
+		case <-done: // the discovery returned, nobody reads the answers anymore
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestDiscoverMTULoopback finds that the loopback interface carries packets
+// up to the largest size tried. It needs a raw socket and is skipped without
+// one.
+func TestDiscoverMTULoopback(t *testing.T) {
+	stats, err := DiscoverMTU(context.Background(), net.IPv4(127, 0, 0, 1), MTUOptions{MaxMTU: 9000, Attempts: 1, Timeout: time.Second})
+	if err != nil {
+		t.Skip(err)
+	}
+	if !stats.Success || stats.PathMTU != 9000 || stats.MaxPayload != 9000-echoOverhead || stats.FailedAt != 0 {
+		t.Errorf("Expected the loopback interface to carry 9000 bytes, got %+v", stats)
+	}
+}
+
+// TestMTUMaxMTU checks that plans cannot ask for sizes no probe can have.
+func TestMTUMaxMTU(t *testing.T) {
+	for size, valid := range map[int]bool{10: false, 67: false, 0: true, 68: true, 65535: true, 65536: false} {
+		params := InputParams{Mode: "mtu", Host: "192.0.2.1", MaxMTU: size}
+		if err := params.Normalize(); (err == nil) != valid {
+			t.Errorf("Expected max_mtu %d to be valid: %t, got %v", size, valid, err)
+		}
+	}
+}
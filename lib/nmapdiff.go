@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+)
+
+// NmapDiff describes how the exposure of a set of hosts changed between two
+// nmap scans. Ports are only compared when both scans covered them.
+type NmapDiff struct {
+	SchemaVersion    int         `json:"schema_version"`
+	OpenedPorts      []NmapStats `json:"opened_ports"`
+	ClosedPorts      []NmapStats `json:"closed_ports"`
+	AppearedHosts    []string    `json:"appeared_hosts"`
+	DisappearedHosts []string    `json:"disappeared_hosts"`
+	ExposureGrew     bool        `json:"exposure_grew"`
+}
+
+// MarshalJSON stamps the diff with the SchemaVersion it is written in.
+func (diff NmapDiff) MarshalJSON() ([]byte, error) {
+	type plain NmapDiff
+	diff.SchemaVersion = SchemaVersion
+	return json.Marshal(plain(diff))
+}
+
+// NmapResults returns the stats of an nmap result, with the protocol of the
+// scan filled in for results written before every stat carried it.
+func NmapResults(output JSONOutput) []NmapStats {
+	stats, _ := output.Stats.([]NmapStats)
+	results := make([]NmapStats, len(stats))
+	for i, stat := range stats {
+		if stat.Protocol == "" {
+			stat.Protocol = cmp.Or(output.InputParams.Protocol, "tcp")
+		}
+		results[i] = stat
+	}
+	return results
+}
+
+// nmapPort identifies a scanned port, as TCP and UDP ports of the same
+// number are different services.
+type nmapPort struct {
+	protocol string
+	port     int
+}
+
+// openPorts folds repeated iterations of a scan into address -> port -> open.
+func openPorts(stats []NmapStats) map[string]map[nmapPort]bool {
+	hosts := make(map[string]map[nmapPort]bool)
+	for _, stat := range stats {
+		if hosts[stat.Address] == nil {
+			hosts[stat.Address] = make(map[nmapPort]bool)
+		}
+		port := nmapPort{cmp.Or(stat.Protocol, "tcp"), stat.Port}
+		hosts[stat.Address][port] = hosts[stat.Address][port] || stat.Success
+	}
+	return hosts
+}
+
+// DiffNmap compares the results of a previous scan with a new one, port by
+// port and protocol. Every open port of a host that only appears in the new
+// scan counts as newly opened.
+func DiffNmap(previous, current []NmapStats) NmapDiff {
+	diff := NmapDiff{
+		OpenedPorts:      make([]NmapStats, 0),
+		ClosedPorts:      make([]NmapStats, 0),
+		AppearedHosts:    make([]string, 0),
+		DisappearedHosts: make([]string, 0),
+	}
+	before, after := openPorts(previous), openPorts(current)
+	for address, ports := range after {
+		known, ok := before[address]
+		if !ok {
+			diff.AppearedHosts = append(diff.AppearedHosts, address)
+		}
+		for port, open := range ports {
+			was, scanned := known[port]
+			if open && (!ok || (scanned && !was)) {
+				diff.OpenedPorts = append(diff.OpenedPorts, NmapStats{Address: address, Protocol: port.protocol, Port: port.port, Success: true})
+			} else if !open && scanned && was {
+				diff.ClosedPorts = append(diff.ClosedPorts, NmapStats{Address: address, Protocol: port.protocol, Port: port.port, Success: false})
+			}
+		}
+	}
+	for address := range before {
+		if _, ok := after[address]; !ok {
+			diff.DisappearedHosts = append(diff.DisappearedHosts, address)
+		}
+	}
+	byAddressAndPort := func(a, b NmapStats) int {
+		return cmp.Or(cmp.Compare(a.Address, b.Address), cmp.Compare(a.Protocol, b.Protocol), cmp.Compare(a.Port, b.Port))
+	}
+	slices.SortFunc(diff.OpenedPorts, byAddressAndPort)
+	slices.SortFunc(diff.ClosedPorts, byAddressAndPort)
+	slices.Sort(diff.AppearedHosts)
+	slices.Sort(diff.DisappearedHosts)
+	diff.ExposureGrew = len(diff.OpenedPorts) > 0
+	return diff
+}
@@ -0,0 +1,68 @@
+package lib
+
+import "testing"
+
+// TestDiffNmap checks opened and closed ports and appearing hosts.
+func TestDiffNmap(t *testing.T) {
+	previous := []NmapStats{
+		{Address: "10.0.0.1", Port: 22, Success: true},
+		{Address: "10.0.0.1", Port: 23, Success: true},
+		{Address: "10.0.0.1", Port: 80, Success: false},
+		{Address: "10.0.0.2", Port: 22, Success: true},
+	}
+	current := []NmapStats{
+		{Address: "10.0.0.1", Port: 22, Success: true},
+		{Address: "10.0.0.1", Port: 23, Success: false},
+		{Address: "10.0.0.1", Port: 80, Success: false},
+		{Address: "10.0.0.1", Port: 80, Success: true},  // second iteration
+		{Address: "10.0.0.1", Port: 443, Success: true}, // not in the previous range
+		{Address: "10.0.0.3", Port: 22, Success: true},
+		{Address: "10.0.0.3", Port: 23, Success: false},
+	}
+	diff := DiffNmap(previous, current)
+	if len(diff.OpenedPorts) != 2 || diff.OpenedPorts[0].Port != 80 || diff.OpenedPorts[1].Address != "10.0.0.3" {
+		t.Errorf("Unexpected opened ports %+v", diff.OpenedPorts)
+	}
+	if len(diff.ClosedPorts) != 1 || diff.ClosedPorts[0].Port != 23 {
+		t.Errorf("Unexpected closed ports %+v", diff.ClosedPorts)
+	}
+	if len(diff.AppearedHosts) != 1 || diff.AppearedHosts[0] != "10.0.0.3" || len(diff.DisappearedHosts) != 1 || diff.DisappearedHosts[0] != "10.0.0.2" {
+		t.Errorf("Unexpected hosts %+v %+v", diff.AppearedHosts, diff.DisappearedHosts)
+	}
+	if !diff.ExposureGrew {
+		t.Error("Expected exposure to grow")
+	}
+	if DiffNmap(current, previous).ExposureGrew != true { // 10.0.0.1:23 reopens
+		t.Error("Expected exposure to grow in reverse")
+	}
+	if DiffNmap(previous, previous).ExposureGrew {
+		t.Error("Expected no change against itself")
+	}
+}
+
+// TestDiffNmapProtocols keeps the TCP and UDP results of the same port apart,
+// including results written before the stats carried their protocol.
+func TestDiffNmapProtocols(t *testing.T) {
+	previous := NmapResults(JSONOutput{
+		InputParams: InputParams{Mode: "nmap", Protocol: "udp"},
+		Stats:       []NmapStats{{Address: "10.0.0.1", Port: 53, Success: false}},
+	})
+	current := []NmapStats{
+		{Address: "10.0.0.1", Protocol: "udp", Port: 53, Success: false},
+		{Address: "10.0.0.1", Protocol: "tcp", Port: 53, Success: true},
+	}
+	if previous[0].Protocol != "udp" {
+		t.Fatalf("Expected the protocol of the scan, got %+v", previous)
+	}
+	if diff := DiffNmap(previous, current); diff.ExposureGrew || len(diff.ClosedPorts) != 0 {
+		t.Errorf("Expected the TCP port not to count against the UDP scan, got %+v", diff)
+	}
+	previous = append(previous, NmapStats{Address: "10.0.0.1", Protocol: "tcp", Port: 53, Success: false})
+	diff := DiffNmap(previous, current)
+	if len(diff.OpenedPorts) != 1 || diff.OpenedPorts[0].Protocol != "tcp" || len(diff.ClosedPorts) != 0 {
+		t.Errorf("Expected only 53/tcp to open, got %+v", diff)
+	}
+	if diff := DiffNmap(current, previous); len(diff.ClosedPorts) != 1 || diff.ClosedPorts[0].Protocol != "tcp" || diff.ExposureGrew {
+		t.Errorf("Expected only 53/tcp to close, got %+v", diff)
+	}
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	NotifyEventFailure  string = "failure"
+	NotifyEventRecovery string = "recovery"
+)
+
+type Notification struct {
+	SchemaVersion  int        `json:"schema_version"`
+	Event          string     `json:"event"`
+	ModuleName     string     `json:"module_name"`
+	Target         string     `json:"target"`
+	Message        string     `json:"message"`
+	Time           int64      `json:"time_unixtime_µs"`
+	FailingSince   int64      `json:"failing_since_unixtime_µs"`
+	SuccessRate    float64    `json:"success_rate"`
+	AverageLatency int64      `json:"average_latency_µs"`
+	Result         JSONOutput `json:"result"`
+}
+
+// MarshalJSON stamps the notification with the SchemaVersion it is written
+// in, for the webhooks and commands that parse it.
+func (notification Notification) MarshalJSON() ([]byte, error) {
+	type plain Notification
+	notification.SchemaVersion = SchemaVersion
+	return json.Marshal(plain(notification))
+}
+
+// Notifier delivers a notification to one sink, e.g. a webhook or a mailbox.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// Evaluate decides whether a result should be reported as a failure: any
+// probe with Success false, a failed DNS lookup or module error, or an average
+// latency above threshold (when threshold is non zero). The message explains
+// why. Closed ports are what a scan finds rather than failures, so nmap only
+// fails on a module error or a failed DNS lookup, as in ExitCode.
+func Evaluate(output JSONOutput, threshold time.Duration) (bool, string) {
+	target := output.ModuleName + " " + output.InputParams.Target()
+	if output.Error != "" {
+		return true, target + " failed: " + output.Error
+	}
+	if output.ModuleName == "nmap" {
+		if output.DNSLookup.Hostname != "" && !output.DNSLookup.Success {
+			return true, target + " failed: the DNS lookup of " + output.DNSLookup.Hostname + " failed"
+		}
+		return false, target + " succeeded"
+	}
+	summary := Summarize(output)
+	if summary.Sent == 0 {
+		return true, target + " failed: no probe was completed"
+	}
+	if summary.Succeeded < summary.Sent {
+		return true, target + " failed: " + strconv.Itoa(summary.Sent-summary.Succeeded) + " of " + strconv.Itoa(summary.Sent) + " probes were unsuccessful"
+	}
+	if threshold > 0 && len(summary.Latencies) > 0 {
+		_, avg, _ := GetMinAvgMax(summary.Latencies)
+		if avg > threshold {
+			return true, target + " is slow: average latency " + avg.String() + " is above threshold " + threshold.String()
+		}
+	}
+	return false, target + " succeeded"
+}
+
+type notifyStateEntry struct {
+	Failing      bool  `json:"failing"`
+	FailingSince int64 `json:"failing_since_unixtime_µs"`
+}
+
+// NotifyState remembers which targets are currently failing so that repeated
+// runs against a failing target notify once, and a recovery notice is sent
+// when it succeeds again. It is persisted as JSON at Path; an empty Path keeps
+// the state in memory only.
+type NotifyState struct {
+	Path    string
+	mutex   sync.Mutex
+	entries map[string]notifyStateEntry
+}
+
+// DefaultNotifyStatePath returns the state file used across command runs.
+func DefaultNotifyStatePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "shint", "notify-state.json")
+}
+
+func LoadNotifyState(path string) (*NotifyState, error) {
+	state := &NotifyState{Path: path, entries: make(map[string]notifyStateEntry)}
+	if path == "" {
+		return state, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state.entries); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+func (state *NotifyState) save() error {
+	if state.Path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(state.Path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(state.Path, data, 0o644)
+}
+
+// Dispatch evaluates output and, when the failing state of its target changed
+// since the last call, sends a failure or recovery notification to every
+// notifier. It returns the notification sent, or nil when nothing changed.
+// The new state is only recorded once a notifier delivered it, so that a
+// notification no sink accepted is sent again by the next call.
+func Dispatch(ctx context.Context, notifiers []Notifier, state *NotifyState, output JSONOutput, threshold time.Duration) (*Notification, error) {
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+	failing, message := Evaluate(output, threshold)
+	key := output.ModuleName + "|" + output.InputParams.Target()
+	now := time.Now()
+
+	// held until the state is recorded, so that concurrent results of the
+	// same target cannot send the notification twice
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	previous := state.entries[key]
+	if failing == previous.Failing {
+		return nil, nil
+	}
+	current := notifyStateEntry{Failing: failing}
+	if failing {
+		current.FailingSince = now.UnixMicro()
+	}
+
+	notification := Notification{
+		Event:        NotifyEventFailure,
+		ModuleName:   output.ModuleName,
+		Target:       output.InputParams.Target(),
+		Message:      message,
+		Time:         now.UnixMicro(),
+		FailingSince: current.FailingSince,
+		SuccessRate:  Summarize(output).SuccessRate(),
+		Result:       output,
+	}
+	if !failing {
+		notification.Event = NotifyEventRecovery
+		notification.FailingSince = previous.FailingSince
+		notification.Message = message + " again after failing for " + now.Sub(time.UnixMicro(previous.FailingSince)).Round(time.Second).String()
+	}
+	if summary := Summarize(output); len(summary.Latencies) > 0 {
+		_, avg, _ := GetMinAvgMax(summary.Latencies)
+		notification.AverageLatency = avg.Microseconds()
+	}
+	var err error
+	delivered := false
+	for _, notifier := range notifiers {
+		if nerr := notifier.Notify(ctx, notification); nerr != nil {
+			err = errors.Join(err, nerr)
+		} else {
+			delivered = true
+		}
+	}
+	if delivered {
+		state.entries[key] = current
+		err = errors.Join(err, state.save())
+	}
+	return &notification, err
+}
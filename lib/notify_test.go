@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func webRound(success bool) JSONOutput {
+	return JSONOutput{
+		InputParams: InputParams{Mode: "web", URL: "https://example.com/health"},
+		ModuleName:  "web",
+		Stats:       []WebStats{{URL: "https://example.com/health", Success: success, TimeTaken: 1000}},
+	}
+}
+
+// TestDispatchDeduplicates checks that a failing target notifies once across
+// runs, and that a recovery notice follows when it succeeds again.
+func TestDispatchDeduplicates(t *testing.T) {
+	received := make(chan Notification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification Notification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Errorf("Failed to decode notification: %v", err)
+		}
+		received <- notification
+	}))
+	defer server.Close()
+
+	notifier, err := ParseNotifier("webhook:" + server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	for i, success := range []bool{true, false, false, true, true} {
+		// reload the state every time to simulate separate command runs
+		state, err := LoadNotifyState(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Dispatch(context.Background(), []Notifier{notifier}, state, webRound(success), 0); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	close(received)
+	events := make([]string, 0)
+	for notification := range received {
+		events = append(events, notification.Event)
+	}
+	if strings.Join(events, ",") != NotifyEventFailure+","+NotifyEventRecovery {
+		t.Errorf("Expected a failure then a recovery notification, got %v", events)
+	}
+}
+
+// TestDispatchRetries sends a failure notification again on the next run
+// when no notifier delivered it.
+func TestDispatchRetries(t *testing.T) {
+	failures, received := 1, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received++
+	}))
+	defer server.Close()
+
+	notifier, err := ParseNotifier("webhook:" + server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	for i := range 3 {
+		state, err := LoadNotifyState(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Dispatch(context.Background(), []Notifier{notifier}, state, webRound(false), 0)
+		if (err != nil) != (i == 0) {
+			t.Errorf("run %d: expected an error only for the failed delivery, got %v", i, err)
+		}
+	}
+	if received != 1 {
+		t.Errorf("Expected the failure to be delivered once on the second run, got %d deliveries", received)
+	}
+}
+
+// TestEvaluateLatencyThreshold checks that a slow but successful result fails.
+func TestEvaluateLatencyThreshold(t *testing.T) {
+	if failing, _ := Evaluate(webRound(true), 0); failing {
+		t.Error("Expected a successful result without threshold to pass")
+	}
+	if failing, message := Evaluate(webRound(true), time.Microsecond); !failing || !strings.Contains(message, "threshold") {
+		t.Errorf("Expected a latency failure, got %v %q", failing, message)
+	}
+}
+
+// TestEvaluateNmap checks that closed ports do not fail a scan, unlike a
+// failed DNS lookup.
+func TestEvaluateNmap(t *testing.T) {
+	scan := JSONOutput{
+		ModuleName:  "nmap",
+		InputParams: InputParams{Mode: "nmap", Host: "db.internal", FromPort: 22, ToPort: 23},
+		DNSLookup:   DNSLookup{Hostname: "db.internal", Success: true},
+		Stats:       []NmapStats{{Port: 22, Success: true}, {Port: 23}},
+	}
+	if failing, message := Evaluate(scan, time.Microsecond); failing {
+		t.Errorf("Expected a scan with a closed port to pass, got %q", message)
+	}
+	scan.DNSLookup.Success = false
+	if failing, message := Evaluate(scan, 0); !failing || !strings.Contains(message, "DNS") {
+		t.Errorf("Expected a failed DNS lookup to fail the scan, got %v %q", failing, message)
+	}
+}
+
+// TestSlackNotifier checks the Slack compatible payload.
+func TestSlackNotifier(t *testing.T) {
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	notifier, _ := ParseNotifier("slack:" + server.URL)
+	if err := notifier.Notify(context.Background(), Notification{Event: NotifyEventFailure, Message: "web is down"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(payload["text"], "web is down") {
+		t.Errorf("Unexpected slack payload %v", payload)
+	}
+}
+
+// TestSMTPNotifier runs the SMTP notifier against a minimal SMTP stand-in.
+func TestSMTPNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	mail := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		indata := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if indata {
+				if line == ".\r\n" {
+					indata = false
+					mail <- data.String()
+					reply("250 OK")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+			switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 OK")
+			case "DATA":
+				indata = true
+				reply("354 Go ahead")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("500 Unknown command")
+			}
+		}
+	}()
+
+	notifier, err := ParseNotifier("smtp://" + listener.Addr().String() + "?from=shint@example.com&to=oncall@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), Notification{Event: NotifyEventFailure, ModuleName: "web", Target: "https://example.com", Message: "web is down"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-mail:
+		if !strings.Contains(body, "Subject: [shint] failure: web https://example.com") || !strings.Contains(body, "web is down") {
+			t.Errorf("Unexpected mail body:\n%s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No mail received")
+	}
+}
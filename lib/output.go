@@ -1,11 +1,20 @@
 package lib
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// SchemaVersion is the version of the JSON output written by this build,
+// recorded in every output as schema_version. Version 1 is the unversioned
+// output of earlier builds, where ICMP timings were in milliseconds and the
+// timeout in seconds was labelled timeout_ms; both are still read.
+const SchemaVersion = 2
+
 type DNSLookup struct {
 	Hostname          string   `json:"hostname"`
 	ResolvedAddresses []string `json:"resolved_addresses"`
@@ -15,28 +24,228 @@ type DNSLookup struct {
 }
 
 type InputParams struct {
-	Mode       string   `json:"module_name"`
-	Sequential bool     `json:"sequential"`
-	Throttle   bool     `json:"throttle"`
-	Host       string   `json:"host"`
-	FromPort   int      `json:"from_port"`
-	ToPort     int      `json:"to_port"`
-	Protocol   string   `json:"protocol"`
-	Timeout    int      `json:"timeout_ms"`
-	Count      int      `json:"count"`
-	Delay      int      `json:"delay_ms"`
-	Payload    int      `json:"payload_bytes"`
-	Method     string   `json:"method"`
-	Data       string   `json:"data"`
-	Headers    []string `json:"headers"`
+	Mode               string     `json:"module_name"`
+	Sequential         bool       `json:"sequential"`
+	Throttle           bool       `json:"throttle"`
+	Host               string     `json:"host"`
+	FromPort           int        `json:"from_port"`
+	ToPort             int        `json:"to_port"`
+	Protocol           string     `json:"protocol"`
+	Timeout            int        `json:"timeout_s"`
+	LegacyTimeout      int        `json:"timeout_ms,omitempty"` // schema 1 label of Timeout, read for compatibility
+	Count              int        `json:"count"`
+	Delay              int        `json:"delay_ms"`
+	Payload            int        `json:"payload_bytes"`
+	Method             string     `json:"method"`
+	Data               string     `json:"data"`
+	Headers            []string   `json:"headers"`
+	URL                string     `json:"url"`
+	WithBody           bool       `json:"with_body"`
+	Send               string     `json:"send"`             // written by telnet after connecting
+	RandomPayload      bool       `json:"random_payload"`   // telnet sends Payload random bytes instead of Send
+	Expect             string     `json:"expect"`           // telnet waits for a response containing it
+	ExpectRegex        bool       `json:"expect_regex"`     // Expect is a regular expression
+	MaxHops            int        `json:"max_hops"`         // highest TTL probed by trace
+	FallbackPort       int        `json:"fallback_port"`    // ping switches to TCP handshakes with it when ICMP fails
+	MaxMTU             int        `json:"max_mtu"`          // largest packet size tried by mtu
+	HTTPVersion        string     `json:"http_version"`     // web speaks only this HTTP version, empty lets ALPN choose
+	Reuse              bool       `json:"reuse"`            // web sends the requests one after another over kept alive connections
+	Form               []string   `json:"form"`             // web sends these name=value fields urlencoded instead of Data
+	Multipart          []FormPart `json:"multipart"`        // web sends these fields as multipart/form-data instead of Data
+	DataSize           int        `json:"data_size_bytes"`  // size of the file or stdin Data names as @path or @-
+	DataSHA256         string     `json:"data_sha256"`      // SHA-256 of that body, whose content is not recorded
+	Output             string     `json:"output"`           // web saves the response bodies to this file, numbered per iteration
+	OutputDir          string     `json:"output_dir"`       // web saves the response bodies to this directory, one file per iteration
+	MaxBody            int64      `json:"max_body_bytes"`   // web reads at most this many bytes of a response body, 0 for all
+	User               string     `json:"user"`             // web authenticates with this user:password, in basic authentication unless Digest is set
+	Digest             bool       `json:"digest"`           // web answers the digest challenge of the server with User
+	Bearer             string     `json:"bearer"`           // web sends this bearer token
+	OAuth2TokenURL     string     `json:"oauth2_token_url"` // web gets a bearer token from this OAuth2 endpoint with the client credentials grant
+	OAuth2ClientID     string     `json:"oauth2_client_id"`
+	OAuth2ClientSecret string     `json:"oauth2_client_secret"`
+	OAuth2Scopes       []string   `json:"oauth2_scopes"`
+	data               []byte     // content of the body Data names, read by LoadBody
+}
+
+// Target returns a short human readable form of what the params probe: the URL
+// for web, host:port for telnet and the bare host for everything else.
+func (params InputParams) Target() string {
+	switch params.Mode {
+	case "web":
+		if params.URL != "" {
+			return params.URL
+		}
+	case "telnet":
+		return params.Host + ":" + strconv.Itoa(params.FromPort)
+	case "trace":
+		if params.FromPort > 0 {
+			return params.Host + ":" + strconv.Itoa(params.FromPort)
+		}
+	}
+	return params.Host
+}
+
+// Normalize validates params received from a plan or over the network and
+// fills in the defaults the command line flags would otherwise provide.
+func (params *InputParams) Normalize() error {
+	params.upgrade()
+	switch params.Mode {
+	case "telnet", "nmap":
+	case "trace":
+		params.Protocol = "icmp"
+		if params.FromPort > 0 {
+			params.Protocol = "tcp"
+		}
+		if params.MaxHops == 0 {
+			params.MaxHops = 30
+		}
+		if params.MaxHops < 1 || params.MaxHops > 255 { // the TTL is an 8-bit field
+			return fmt.Errorf("max_hops must be between 1 and 255")
+		}
+	case "mtu":
+		params.Protocol = "icmp"
+		if params.MaxMTU == 0 {
+			params.MaxMTU = 1500
+		}
+		if params.MaxMTU < minMTU || params.MaxMTU > 65535 {
+			return fmt.Errorf("max_mtu must be between 68 and 65535 bytes")
+		}
+	case "ping", "icmp":
+		params.Mode = "icmp"
+		if params.Protocol != "tcp" {
+			params.Protocol = "icmp"
+		} else if params.FromPort == 0 {
+			return fmt.Errorf("tcp ping requires from_port")
+		}
+	case "web":
+		if params.URL == "" && params.Host != "" {
+			params.URL = "https://" + params.Host
+		}
+		if URL, err := url.Parse(params.URL); err != nil {
+			return err
+		} else if params.Host == "" {
+			params.Host = URL.Host
+		}
+		if params.Method == "" {
+			params.Method = "GET"
+		}
+		if err := params.checkHTTPVersion(); err != nil {
+			return err
+		}
+		if (params.Data != "" && len(params.Form) > 0) || (len(params.Multipart) > 0 && (params.Data != "" || len(params.Form) > 0)) {
+			return fmt.Errorf("web sends only one of data, form and multipart as the request body")
+		}
+		if params.Output != "" && params.OutputDir != "" {
+			return fmt.Errorf("web saves the response bodies to either output or output_dir")
+		}
+		if params.MaxBody < 0 {
+			return fmt.Errorf("max_body_bytes cannot be negative")
+		}
+		if err := params.checkAuth(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown module_name '%s'", params.Mode)
+	}
+	if params.Host == "" && params.URL == "" {
+		return fmt.Errorf("%s requires a host", params.Mode)
+	}
+	if params.Mode == "telnet" && params.FromPort == 0 {
+		return fmt.Errorf("telnet requires from_port")
+	}
+	if _, err := params.ExpectPattern(); err != nil {
+		return err
+	}
+	if params.Protocol == "" {
+		params.Protocol = "tcp"
+	}
+	if params.ToPort == 0 {
+		params.ToPort = params.FromPort
+	}
+	if params.Count == 0 {
+		params.Count = 1
+	}
+	if params.Timeout == 0 {
+		params.Timeout = 5
+	}
+	return nil
+}
+
+// upgrade moves the fields of schema 1 params to their current names.
+func (params *InputParams) upgrade() {
+	if params.Timeout == 0 {
+		params.Timeout = params.LegacyTimeout
+	}
+	params.LegacyTimeout = 0
 }
 
 type TelnetStats struct {
-	Address   string `json:"address"`
-	Success   bool   `json:"success"`
-	RecvTime  int64  `json:"recv_unixtime_µs"`
-	SentTime  int64  `json:"sent_unixtime_µs"`
-	TimeTaken int64  `json:"time_taken_µs"`
+	Address       string `json:"address"`
+	Success       bool   `json:"success"`
+	RecvTime      int64  `json:"recv_unixtime_µs"`
+	SentTime      int64  `json:"sent_unixtime_µs"`
+	TimeTaken     int64  `json:"time_taken_µs"`
+	ConnectTime   int64  `json:"connect_time_µs"`
+	AppRTT        int64  `json:"app_rtt_µs"` // from writing the payload to the (expected) response
+	BytesSent     int    `json:"bytes_sent"`
+	BytesReceived int    `json:"bytes_received"`
+	Response      string `json:"response"` // first bytes of the response
+	State         string `json:"state"`    // open, open|filtered or closed, for UDP only
+	Error         string `json:"error"`
+}
+
+// HTTP versions the web module can be restricted to with
+// InputParams.HTTPVersion.
+const (
+	HTTP11 = "1.1"
+	HTTP2  = "2"
+	H2C    = "h2c" // HTTP/2 without TLS
+	HTTP3  = "3"
+)
+
+// checkHTTPVersion tells whether the URL scheme allows the HTTP version: h2c
+// is plain text while HTTP/2 and HTTP/3 are only negotiated over TLS.
+func (params InputParams) checkHTTPVersion() error {
+	URL, err := url.Parse(params.URL)
+	if err != nil {
+		return err
+	}
+	switch params.HTTPVersion {
+	case "", HTTP11:
+	case H2C:
+		if URL.Scheme != "http" {
+			return fmt.Errorf("h2c requires an http:// URL")
+		}
+	case HTTP2, HTTP3:
+		if URL.Scheme != "https" {
+			return fmt.Errorf("HTTP/%s requires an https:// URL, use h2c for HTTP/2 without TLS", params.HTTPVersion)
+		}
+	default:
+		return fmt.Errorf("unknown http_version '%s', expected %s, %s, %s or %s", params.HTTPVersion, HTTP11, HTTP2, H2C, HTTP3)
+	}
+	return nil
+}
+
+// checkAuth tells whether a single authentication method is set, and set
+// completely.
+func (params InputParams) checkAuth() error {
+	methods := 0
+	for _, set := range []bool{params.User != "", params.Bearer != "", params.OAuth2TokenURL != ""} {
+		if set {
+			methods++
+		}
+	}
+	switch {
+	case methods > 1:
+		return fmt.Errorf("web authenticates with only one of user, bearer and oauth2_token_url")
+	case params.Digest && params.User == "":
+		return fmt.Errorf("digest authentication requires a user")
+	case params.User != "" && !strings.Contains(params.User, ":"):
+		return fmt.Errorf("user must be given as user:password")
+	case params.OAuth2TokenURL != "" && params.OAuth2ClientID == "":
+		return fmt.Errorf("the OAuth2 client credentials grant requires a client id")
+	}
+	return nil
 }
 
 type WebStats struct {
@@ -50,12 +259,18 @@ type WebStats struct {
 	TimeTaken       int64          `json:"time_taken_µs"`
 	BytesDownloaded int            `json:"bytes_downloaded"` // added field to store the number of bytes downloaded
 	StatusCode      int            `json:"status_code"`      // added field to store the HTTP status code
+	Protocol        string         `json:"protocol"`         // HTTP version of the response, e.g. HTTP/2.0
+	ALPN            string         `json:"alpn"`             // protocol negotiated during the TLS handshake
+	Reused          bool           `json:"reused"`           // sent over a connection already used, as a new stream for HTTP/2 and HTTP/3
+	BodyFile        string         `json:"body_file"`        // where the response body was saved
 }
 
 type NmapStats struct {
-	Address string `json:"address"`
-	Port    int    `json:"port"`
-	Success bool   `json:"success"`
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"` // tcp or udp
+	Port     int    `json:"port"`
+	Success  bool   `json:"success"`
+	State    string `json:"state"` // open, open|filtered or closed, for UDP only
 }
 
 type ICMPStats struct {
@@ -63,19 +278,157 @@ type ICMPStats struct {
 	Success     bool   `json:"success"`
 	Sequence    int    `json:"sequence"` // added Sequence field to store the sequence number of the ICMP packet
 	PayloadSize int    `json:"payload_size_bytes"`
-	RecvTime    int64  `json:"recv_unixtime_ms"`
-	SentTime    int64  `json:"sent_unixtime_ms"`
-	TimeTaken   int64  `json:"time_taken_ms"`
+	RecvTime    int64  `json:"recv_unixtime_µs"`
+	SentTime    int64  `json:"sent_unixtime_µs"`
+	TimeTaken   int64  `json:"time_taken_µs"`
+	Method      string `json:"method"` // icmp, or tcp for handshakes to Port
+	Port        int    `json:"port"`
+	Duplicates  int    `json:"duplicates"` // replies received after the first one
+	Error       string `json:"error"`
+}
+
+// UnmarshalJSON also reads the millisecond timings that ICMP results carried
+// before they had microsecond precision, so that older history records and
+// saved outputs still summarize correctly.
+func (stat *ICMPStats) UnmarshalJSON(data []byte) error {
+	type plain ICMPStats
+	var raw struct {
+		plain
+		RecvTimeMs  *int64 `json:"recv_unixtime_ms"`
+		SentTimeMs  *int64 `json:"sent_unixtime_ms"`
+		TimeTakenMs *int64 `json:"time_taken_ms"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*stat = ICMPStats(raw.plain)
+	for _, legacy := range []struct {
+		millis *int64
+		micros *int64
+	}{{raw.RecvTimeMs, &stat.RecvTime}, {raw.SentTimeMs, &stat.SentTime}, {raw.TimeTakenMs, &stat.TimeTaken}} {
+		if legacy.millis != nil && *legacy.micros == 0 {
+			*legacy.micros = *legacy.millis * 1000
+		}
+	}
+	return nil
+}
+
+// TraceStats describes one hop of a trace, over all the queries sent with its
+// TTL. Address is the first router that answered, Addresses all of them when
+// the path is load balanced.
+type TraceStats struct {
+	TTL       int      `json:"ttl"`
+	Address   string   `json:"address"`
+	Addresses []string `json:"addresses"`
+	Hostname  string   `json:"hostname"`
+	Reached   bool     `json:"reached"` // the destination itself answered
+	Success   bool     `json:"success"`
+	Sent      int      `json:"sent"`
+	Received  int      `json:"received"`
+	Loss      float64  `json:"loss_percent"`
+	RTTs      []int64  `json:"rtts_µs"`
+	Min       int64    `json:"min_µs"`
+	Avg       int64    `json:"avg_µs"`
+	Max       int64    `json:"max_µs"`
+	StdDev    int64    `json:"stddev_µs"`
+}
+
+// MTUProbe is one echo request of a path MTU discovery, Size being the size
+// of the IP packet sent with the don't fragment bit.
+type MTUProbe struct {
+	Size       int    `json:"size_bytes"`
+	Success    bool   `json:"success"`
+	Result     string `json:"result"`       // reply, fragmentation needed, message too long or timeout
+	From       string `json:"from"`         // address that answered
+	NextHopMTU int    `json:"next_hop_mtu"` // reported by the router that could not forward the packet
+	TimeTaken  int64  `json:"time_taken_µs"`
 }
+
+// MTUStats is the outcome of a path MTU discovery: the largest packet that
+// reached Address unfragmented, and the smallest that did not and why.
+type MTUStats struct {
+	Address    string     `json:"address"`
+	Success    bool       `json:"success"` // the path MTU could be determined
+	PathMTU    int        `json:"path_mtu_bytes"`
+	MaxPayload int        `json:"max_payload_bytes"` // ICMP data bytes in the largest packet
+	FailedAt   int        `json:"failed_at_bytes"`   // smallest packet size that did not pass, 0 if none
+	FailedBy   string     `json:"failed_by"`         // router that refused it, "local" for the own interface
+	FailReason string     `json:"fail_reason"`
+	Probes     []MTUProbe `json:"probes"`
+}
+
 type JSONOutput struct {
-	InputParams    InputParams `json:"input_params"`
-	ModuleName     string      `json:"module_name"`
-	DNSLookup      DNSLookup   `json:"dns_lookup"`
-	Stats          any         `json:"stats"`
-	EndTime        int64       `json:"end_time_unixtime_µs"`
-	StartTime      int64       `json:"start_time_unixtime_µs"`
-	TotalTimeTaken int64       `json:"total_time_taken_µs"`
-	Error          string      `json:"error"`
+	SchemaVersion  int            `json:"schema_version"`
+	InputParams    InputParams    `json:"input_params"`
+	ModuleName     string         `json:"module_name"`
+	DNSLookup      DNSLookup      `json:"dns_lookup"`
+	Stats          any            `json:"stats"`
+	EndTime        int64          `json:"end_time_unixtime_µs"`
+	StartTime      int64          `json:"start_time_unixtime_µs"`
+	TotalTimeTaken int64          `json:"total_time_taken_µs"`
+	Error          string         `json:"error"`
+	Cancelled      bool           `json:"cancelled"`
+	Analysis       []PingAnalysis `json:"analysis"` // jitter, reordering and loss per address, for icmp only
+	Reuse          *WebReuse      `json:"reuse"`    // cold and warm latency, for web with connection reuse only
+}
+
+// MarshalJSON stamps the output with the SchemaVersion it is written in.
+// Outputs read from older versions are upgraded when decoded, so they are
+// written in the current version too.
+func (output JSONOutput) MarshalJSON() ([]byte, error) {
+	type plain JSONOutput
+	output.SchemaVersion = SchemaVersion
+	return json.Marshal(plain(output))
+}
+
+// UnmarshalJSON decodes stats into the typed slice of the module that produced
+// them, so that outputs read back from disk or over HTTP can be summarized.
+func (output *JSONOutput) UnmarshalJSON(data []byte) error {
+	type plain JSONOutput
+	var raw struct {
+		plain
+		Stats json.RawMessage `json:"stats"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*output = JSONOutput(raw.plain)
+	output.InputParams.upgrade()
+	if len(raw.Stats) == 0 || string(raw.Stats) == "null" {
+		return nil
+	}
+	var err error
+	switch output.ModuleName {
+	case "telnet":
+		stats := make([]TelnetStats, 0)
+		err = json.Unmarshal(raw.Stats, &stats)
+		output.Stats = stats
+	case "web":
+		stats := make([]WebStats, 0)
+		err = json.Unmarshal(raw.Stats, &stats)
+		output.Stats = stats
+	case "icmp":
+		stats := make([]ICMPStats, 0)
+		err = json.Unmarshal(raw.Stats, &stats)
+		output.Stats = stats
+	case "nmap":
+		stats := make([]NmapStats, 0)
+		err = json.Unmarshal(raw.Stats, &stats)
+		output.Stats = stats
+	case "trace":
+		stats := make([]TraceStats, 0)
+		err = json.Unmarshal(raw.Stats, &stats)
+		output.Stats = stats
+	case "mtu":
+		var stats MTUStats
+		err = json.Unmarshal(raw.Stats, &stats)
+		output.Stats = stats
+	default:
+		var stats any
+		err = json.Unmarshal(raw.Stats, &stats)
+		output.Stats = stats
+	}
+	return err
 }
 
 func LogWithTimestamp(log string, iserror bool) string {
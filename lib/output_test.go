@@ -0,0 +1,20 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestICMPStatsLegacyUnits reads an ICMP result saved with millisecond
+// timings into the microsecond fields.
+func TestICMPStatsLegacyUnits(t *testing.T) {
+	var output JSONOutput
+	data := `{"module_name":"icmp","stats":[{"address":"192.0.2.1","success":true,"sequence":1,"sent_unixtime_ms":1000,"recv_unixtime_ms":1009,"time_taken_ms":9}]}`
+	if err := json.Unmarshal([]byte(data), &output); err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := output.Stats.([]ICMPStats)
+	if len(stats) != 1 || stats[0].SentTime != 1000000 || stats[0].RecvTime != 1009000 || stats[0].TimeTaken != 9000 {
+		t.Errorf("Expected the millisecond timings in microseconds, got %+v", stats)
+	}
+}
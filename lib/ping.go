@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// Methods a ping is made with, recorded in ICMPStats.Method.
+const (
+	PingICMP = "icmp"
+	PingTCP  = "tcp"
+)
+
+// TCPPing measures a TCP handshake with address:port as a ping: the stat
+// succeeds when the connection is accepted and its time is the handshake
+// time.
+func TCPPing(address string, port int, sequence int, timeout time.Duration) ICMPStats {
+	stat := ICMPStats{Address: address, Sequence: sequence, Method: PingTCP, Port: port}
+	dialer := net.Dialer{Timeout: timeout}
+	sent := time.Now()
+	stat.SentTime = sent.UnixMicro()
+	conn, err := dialer.Dial(Protocol, net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		stat.Error = err.Error()
+		return stat
+	}
+	elapsed := time.Since(sent)
+	conn.Close()
+	stat.Success = true
+	stat.RecvTime = sent.Add(elapsed).UnixMicro()
+	stat.TimeTaken = elapsed.Microseconds()
+	return stat
+}
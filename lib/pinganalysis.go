@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"math"
+	"sort"
+)
+
+// PingAnalysis qualifies the replies of one address beyond their latency:
+// how much it varies, whether replies are reordered or duplicated and how the
+// losses are spread over the run.
+type PingAnalysis struct {
+	Address          string       `json:"address"`
+	Jitter           int64        `json:"jitter_µs"` // interarrival jitter of RFC 3550
+	OutOfOrder       int          `json:"out_of_order"`
+	Duplicates       int          `json:"duplicates"`
+	LossBursts       int          `json:"loss_bursts"`        // runs of consecutive lost requests
+	LongestLossBurst int          `json:"longest_loss_burst"` // requests lost in a row at most
+	Timeline         []PingSecond `json:"timeline"`
+}
+
+// PingSecond sums up the requests sent during one second of a ping run.
+type PingSecond struct {
+	Second   int     `json:"second"` // since the first request
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss_percent"`
+	Avg      int64   `json:"avg_µs"`
+}
+
+// AnalyzePing computes a PingAnalysis per address of stats, in the order the
+// addresses first appear.
+func AnalyzePing(stats []ICMPStats) []PingAnalysis {
+	addresses := make([]string, 0)
+	byAddress := make(map[string][]ICMPStats)
+	for _, stat := range stats {
+		if _, ok := byAddress[stat.Address]; !ok {
+			addresses = append(addresses, stat.Address)
+		}
+		byAddress[stat.Address] = append(byAddress[stat.Address], stat)
+	}
+	analyses := make([]PingAnalysis, 0, len(addresses))
+	for _, address := range addresses {
+		analyses = append(analyses, analyzeAddress(address, byAddress[address]))
+	}
+	return analyses
+}
+
+// analyzeAddress analyzes the results of one address.
+func analyzeAddress(address string, stats []ICMPStats) PingAnalysis {
+	analysis := PingAnalysis{Address: address, Timeline: make([]PingSecond, 0)}
+	sorted := append([]ICMPStats{}, stats...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Sequence < sorted[j].Sequence })
+
+	// RFC 3550 section 6.4.1: J += (|D(i-1,i)| - J) / 16, where D is the
+	// difference of the transit times of consecutive replies, here their RTTs.
+	var jitter float64
+	var previous int64
+	replied, burst := false, 0
+	for _, stat := range sorted {
+		analysis.Duplicates += stat.Duplicates
+		if !stat.Success {
+			if burst == 0 {
+				analysis.LossBursts++
+			}
+			burst++
+			analysis.LongestLossBurst = max(analysis.LongestLossBurst, burst)
+			continue
+		}
+		burst = 0
+		rtt := stat.TimeTaken
+		if replied {
+			jitter += (math.Abs(float64(rtt-previous)) - jitter) / 16
+		}
+		previous, replied = rtt, true
+	}
+	analysis.Jitter = int64(math.Round(jitter))
+
+	// A reply is out of order when a later request was answered before it.
+	received := make([]ICMPStats, 0, len(sorted))
+	for _, stat := range sorted {
+		if stat.Success {
+			received = append(received, stat)
+		}
+	}
+	sort.SliceStable(received, func(i, j int) bool { return received[i].RecvTime < received[j].RecvTime })
+	highest := 0
+	for _, stat := range received {
+		if stat.Sequence < highest {
+			analysis.OutOfOrder++
+		}
+		highest = max(highest, stat.Sequence)
+	}
+
+	if len(sorted) == 0 {
+		return analysis
+	}
+	first := sorted[0].SentTime
+	for _, stat := range sorted {
+		first = min(first, stat.SentTime)
+	}
+	seconds := make(map[int]*PingSecond)
+	totals := make(map[int]int64)
+	for _, stat := range sorted {
+		second := int((stat.SentTime - first) / 1000000)
+		bucket, ok := seconds[second]
+		if !ok {
+			bucket = &PingSecond{Second: second}
+			seconds[second] = bucket
+		}
+		bucket.Sent++
+		if stat.Success {
+			bucket.Received++
+			totals[second] += stat.TimeTaken
+		}
+	}
+	for _, bucket := range seconds {
+		bucket.Loss = float64(bucket.Sent-bucket.Received) * 100 / float64(bucket.Sent)
+		if bucket.Received > 0 {
+			bucket.Avg = totals[bucket.Second] / int64(bucket.Received)
+		}
+		analysis.Timeline = append(analysis.Timeline, *bucket)
+	}
+	sort.Slice(analysis.Timeline, func(i, j int) bool { return analysis.Timeline[i].Second < analysis.Timeline[j].Second })
+	return analysis
+}
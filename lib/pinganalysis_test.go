@@ -0,0 +1,42 @@
+package lib
+
+import "testing"
+
+// TestAnalyzePing checks the jitter, reordering, duplicates, loss bursts and
+// timeline computed from a run with known replies.
+func TestAnalyzePing(t *testing.T) {
+	stats := []ICMPStats{
+		{Address: "192.0.2.1", Sequence: 1, Success: true, SentTime: 0, RecvTime: 10000, TimeTaken: 10000},
+		{Address: "192.0.2.1", Sequence: 2, Success: true, SentTime: 500000, RecvTime: 530000, TimeTaken: 30000, Duplicates: 1},
+		{Address: "192.0.2.1", Sequence: 4, Success: true, SentTime: 1500000, RecvTime: 1510000, TimeTaken: 10000},
+		{Address: "192.0.2.1", Sequence: 3, Success: true, SentTime: 1000000, RecvTime: 1520000, TimeTaken: 520000},
+		{Address: "192.0.2.1", Sequence: 5, SentTime: 2000000},
+		{Address: "192.0.2.1", Sequence: 6, SentTime: 2500000},
+		{Address: "192.0.2.1", Sequence: 7, Success: true, SentTime: 3000000, RecvTime: 3010000, TimeTaken: 10000},
+		{Address: "192.0.2.1", Sequence: 8, SentTime: 3500000},
+	}
+	analyses := AnalyzePing(stats)
+	if len(analyses) != 1 {
+		t.Fatalf("Expected one address, got %+v", analyses)
+	}
+	analysis := analyses[0]
+	// |D| in µs: 20000, 490000, 510000, 0 -> J = 1250, 31796.9, 61684.6, 57829.3
+	if analysis.Jitter != 57829 {
+		t.Errorf("Expected a jitter of 57829µs, got %d", analysis.Jitter)
+	}
+	if analysis.OutOfOrder != 1 || analysis.Duplicates != 1 {
+		t.Errorf("Expected 1 reply out of order and 1 duplicate, got %d and %d", analysis.OutOfOrder, analysis.Duplicates)
+	}
+	if analysis.LossBursts != 2 || analysis.LongestLossBurst != 2 {
+		t.Errorf("Expected 2 loss bursts of at most 2, got %d and %d", analysis.LossBursts, analysis.LongestLossBurst)
+	}
+	if len(analysis.Timeline) != 4 {
+		t.Fatalf("Expected 4 seconds of timeline, got %+v", analysis.Timeline)
+	}
+	if second := analysis.Timeline[0]; second.Sent != 2 || second.Received != 2 || second.Avg != 20000 {
+		t.Errorf("Unexpected first second %+v", second)
+	}
+	if second := analysis.Timeline[2]; second.Sent != 2 || second.Loss != 100 {
+		t.Errorf("Unexpected third second %+v", second)
+	}
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Plan is a declarative list of checks executed by the run command. It is
+// read from YAML or JSON; every check accepts the same fields as the
+// input_params block of the JSON output, so a previous run can be pasted in.
+type Plan struct {
+	Name        string      `json:"name"`
+	Concurrency int         `json:"concurrency"`
+	Checks      []PlanCheck `json:"checks"`
+}
+
+type PlanCheck struct {
+	Name string `json:"name"`
+	InputParams
+	Replay *InputParams `json:"input_params"` // takes precedence over the inline fields when present
+	Assert PlanExpect   `json:"assert"`       // not "expect", which is the response telnet waits for
+}
+
+// PlanExpect lists what a check must satisfy to pass. Unset fields are not
+// checked, except the success rate which defaults to 100% for everything but nmap.
+type PlanExpect struct {
+	SuccessRate *float64 `json:"success_rate"`
+	MaxLatency  int      `json:"max_latency_ms"`
+	StatusCode  int      `json:"status_code"`
+	OpenPorts   []int    `json:"open_ports"`
+	ClosedPorts []int    `json:"closed_ports"`
+}
+
+type PlanResult struct {
+	Name     string     `json:"name"`
+	Passed   bool       `json:"passed"`
+	Failures []string   `json:"failures"`
+	Result   JSONOutput `json:"result"`
+}
+
+type PlanReport struct {
+	SchemaVersion  int          `json:"schema_version"`
+	Name           string       `json:"name"`
+	Passed         int          `json:"passed"`
+	Failed         int          `json:"failed"`
+	Checks         []PlanResult `json:"checks"`
+	EndTime        int64        `json:"end_time_unixtime_µs"`
+	StartTime      int64        `json:"start_time_unixtime_µs"`
+	TotalTimeTaken int64        `json:"total_time_taken_µs"`
+	Cancelled      bool         `json:"cancelled"`
+}
+
+// MarshalJSON stamps the report with the SchemaVersion it is written in.
+func (report PlanReport) MarshalJSON() ([]byte, error) {
+	type plain PlanReport
+	report.SchemaVersion = SchemaVersion
+	return json.Marshal(plain(report))
+}
+
+// ExitCode classifies a run: success when every check passed, failure when
+// none did and partial otherwise. An interrupted run is never a success, as
+// the checks it did not get to are unknown.
+func (report PlanReport) ExitCode() int {
+	switch {
+	case report.Failed == 0 && !report.Cancelled:
+		return ExitSuccess
+	case report.Passed == 0:
+		return ExitFailure
+	}
+	return ExitPartial
+}
+
+// LoadPlan reads a plan file. YAML is decoded generically and re-encoded as
+// JSON so that the json tags of InputParams are the single source of field names.
+func LoadPlan(path string) (Plan, error) {
+	var plan Plan
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return plan, err
+	}
+	var generic any
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return plan, err
+	}
+	if root, ok := generic.(map[string]any); ok {
+		checks, _ := root["checks"].([]any)
+		for i, check := range checks {
+			if check, ok := check.(map[string]any); ok {
+				if _, ok := check["expect"].(map[string]any); ok {
+					return plan, fmt.Errorf("check #%d: the expectations of a check are given under assert, expect is the response telnet waits for", i+1)
+				}
+			}
+		}
+	}
+	data, err = json.Marshal(generic)
+	if err != nil {
+		return plan, err
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return plan, err
+	}
+	if len(plan.Checks) == 0 {
+		return plan, fmt.Errorf("plan %s has no checks", path)
+	}
+	for i := range plan.Checks {
+		if err := plan.Checks[i].normalize(); err != nil {
+			return plan, fmt.Errorf("check #%d: %w", i+1, err)
+		}
+	}
+	return plan, nil
+}
+
+// normalize applies a pasted input_params block and the input defaults, and
+// reads the request body files of the check.
+func (check *PlanCheck) normalize() error {
+	if check.Replay != nil {
+		check.InputParams = *check.Replay
+		check.Replay = nil
+	}
+	if err := check.InputParams.Normalize(); err != nil {
+		return err
+	}
+	if err := check.InputParams.LoadBody(nil); err != nil {
+		return err
+	}
+	if check.Name == "" {
+		check.Name = check.Mode + " " + check.Target()
+	}
+	return nil
+}
+
+// Verify compares the result of a check against its expectations and returns
+// the reasons it failed, if any.
+func (check PlanCheck) Verify(output JSONOutput) []string {
+	failures := make([]string, 0)
+	if output.Error != "" {
+		failures = append(failures, output.Error)
+	}
+	summary := Summarize(output)
+	expect := check.Assert
+	if expect.SuccessRate != nil || output.ModuleName != "nmap" {
+		rate := 100.0
+		if expect.SuccessRate != nil {
+			rate = *expect.SuccessRate
+		}
+		if summary.SuccessRate() < rate {
+			failures = append(failures, "success rate "+strconv.FormatFloat(summary.SuccessRate(), 'f', 1, 64)+"% is below "+strconv.FormatFloat(rate, 'f', 1, 64)+"%")
+		}
+	}
+	if expect.MaxLatency > 0 && len(summary.Latencies) > 0 {
+		limit := time.Duration(expect.MaxLatency) * time.Millisecond
+		if highest := slices.Max(summary.Latencies); highest > limit {
+			failures = append(failures, "latency "+highest.String()+" is above "+limit.String())
+		}
+	}
+	if stats, ok := output.Stats.([]WebStats); ok && expect.StatusCode != 0 {
+		for _, stat := range stats {
+			if stat.Success && stat.StatusCode != expect.StatusCode {
+				failures = append(failures, "status code "+strconv.Itoa(stat.StatusCode)+" is not "+strconv.Itoa(expect.StatusCode))
+				break
+			}
+		}
+	}
+	if stats, ok := output.Stats.([]NmapStats); ok {
+		open := make(map[int]bool)
+		for _, stat := range stats {
+			open[stat.Port] = open[stat.Port] || stat.Success
+		}
+		for _, port := range expect.OpenPorts {
+			if !open[port] {
+				failures = append(failures, "port "+strconv.Itoa(port)+" is not open")
+			}
+		}
+		for _, port := range expect.ClosedPorts {
+			if open[port] {
+				failures = append(failures, "port "+strconv.Itoa(port)+" is open")
+			}
+		}
+	}
+	return failures
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadPlan checks defaults, inline params and pasted input_params blocks.
+func TestLoadPlan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.yaml")
+	plan := `
+name: smoke
+checks:
+  - name: api
+    module_name: web
+    url: https://example.com/health
+    headers: ["accept: application/json"]
+    assert:
+      status_code: 200
+  - input_params: {"module_name": "telnet", "host": "example.com", "from_port": 443, "to_port": 443, "protocol": "tcp", "timeout_ms": 2, "count": 3, "delay_ms": 0}
+  - module_name: ping
+    host: example.com
+  - name: redis
+    module_name: telnet
+    host: cache.internal
+    from_port: 6379
+    send: "PING\r\n"
+    expect: PONG
+    assert:
+      max_latency_ms: 50
+`
+	if err := os.WriteFile(path, []byte(plan), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Checks) != 4 {
+		t.Fatalf("Expected 4 checks, got %d", len(loaded.Checks))
+	}
+	web := loaded.Checks[0]
+	if web.Method != "GET" || web.Count != 1 || web.Timeout != 5 || len(web.Headers) != 1 || web.Assert.StatusCode != 200 {
+		t.Errorf("Unexpected web check %+v", web)
+	}
+	telnet := loaded.Checks[1]
+	if telnet.Mode != "telnet" || telnet.Count != 3 || telnet.Timeout != 2 || telnet.Name != "telnet example.com:443" {
+		t.Errorf("Unexpected replayed telnet check %+v", telnet)
+	}
+	if loaded.Checks[2].Mode != "icmp" {
+		t.Errorf("Expected ping to be normalized to icmp, got %s", loaded.Checks[2].Mode)
+	}
+	redis := loaded.Checks[3]
+	if redis.Send != "PING\r\n" || redis.Expect != "PONG" || redis.Assert.MaxLatency != 50 {
+		t.Errorf("Expected the telnet exchange and the assertions of the check, got %+v", redis)
+	}
+
+	if err := os.WriteFile(path, []byte("checks:\n  - module_name: web\n    url: https://example.com\n    expect:\n      status_code: 200\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPlan(path); err == nil {
+		t.Error("Expected an error for assertions given under expect")
+	}
+}
+
+// TestPlanVerify checks the expectations against a result.
+func TestPlanVerify(t *testing.T) {
+	check := PlanCheck{Assert: PlanExpect{StatusCode: 200, MaxLatency: 100}}
+	output := JSONOutput{ModuleName: "web", Stats: []WebStats{{Success: true, StatusCode: 503, TimeTaken: 200000}}}
+	if failures := check.Verify(output); len(failures) != 2 {
+		t.Errorf("Expected status code and latency failures, got %v", failures)
+	}
+	nmap := PlanCheck{Assert: PlanExpect{OpenPorts: []int{22}, ClosedPorts: []int{23}}}
+	output = JSONOutput{ModuleName: "nmap", Stats: []NmapStats{{Port: 22, Success: true}, {Port: 23, Success: false}}}
+	if failures := nmap.Verify(output); len(failures) != 0 {
+		t.Errorf("Expected nmap check to pass, got %v", failures)
+	}
+}
+
+// TestPlanReportExitCode checks that an interrupted run does not pass.
+func TestPlanReportExitCode(t *testing.T) {
+	for _, test := range []struct {
+		report PlanReport
+		code   int
+	}{
+		{PlanReport{Passed: 3}, ExitSuccess},
+		{PlanReport{Passed: 2, Failed: 1}, ExitPartial},
+		{PlanReport{Failed: 3}, ExitFailure},
+		{PlanReport{Cancelled: true}, ExitFailure},
+		{PlanReport{Passed: 2, Cancelled: true}, ExitPartial},
+		{PlanReport{Failed: 1, Cancelled: true}, ExitFailure},
+	} {
+		if code := test.report.ExitCode(); code != test.code {
+			t.Errorf("Expected %d for %+v, got %d", test.code, test.report, code)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReplaySide condenses one run for the side by side comparison of a replay.
+type ReplaySide struct {
+	Sent        int     `json:"sent"`
+	Succeeded   int     `json:"succeeded"`
+	SuccessRate float64 `json:"success_rate"`
+	MinLatency  int64   `json:"min_latency_µs"`
+	AvgLatency  int64   `json:"avg_latency_µs"`
+	MaxLatency  int64   `json:"max_latency_µs"`
+}
+
+type ReplayComparison struct {
+	SchemaVersion int        `json:"schema_version"`
+	Command       string     `json:"command"`
+	Original      ReplaySide `json:"original"`
+	Replay        ReplaySide `json:"replay"`
+	Result        JSONOutput `json:"result"`
+}
+
+// MarshalJSON stamps the comparison with the SchemaVersion it is written in.
+func (comparison ReplayComparison) MarshalJSON() ([]byte, error) {
+	type plain ReplayComparison
+	comparison.SchemaVersion = SchemaVersion
+	return json.Marshal(plain(comparison))
+}
+
+func NewReplaySide(output JSONOutput) ReplaySide {
+	summary := Summarize(output)
+	side := ReplaySide{Sent: summary.Sent, Succeeded: summary.Succeeded, SuccessRate: summary.SuccessRate()}
+	if len(summary.Latencies) > 0 {
+		min, avg, max := GetMinAvgMax(summary.Latencies)
+		side.MinLatency, side.AvgLatency, side.MaxLatency = min.Microseconds(), avg.Microseconds(), max.Microseconds()
+	}
+	return side
+}
+
+// LoadJSONOutput reads a result previously printed with --json and makes sure
+// its input_params can be run again. Web results written before the url was
+// recorded in input_params fall back to the url of their first request. Like
+// the params an agent receives, they neither read nor write local files.
+func LoadJSONOutput(path string) (JSONOutput, error) {
+	var output JSONOutput
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return output, err
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		return output, err
+	}
+	params := &output.InputParams
+	if params.Mode == "" {
+		params.Mode = output.ModuleName
+	}
+	if params.Mode == "web" && params.URL == "" {
+		if stats, ok := output.Stats.([]WebStats); ok && len(stats) > 0 {
+			params.URL = stats[0].URL
+		} else if params.Host != "" {
+			params.URL = "https://" + params.Host
+		}
+	}
+	if params.Host == "" && params.URL == "" {
+		return output, fmt.Errorf("%s does not contain input_params to replay", path)
+	}
+	if params.IsRedacted() {
+		return output, fmt.Errorf("the credentials of %s were redacted, run the %s command again with them instead of replaying it", path, params.Mode)
+	}
+	// a result handed over by someone else must not upload local files
+	// or write over them
+	if params.HasBodyFiles() {
+		return output, fmt.Errorf("%s sends request bodies read from files, run the %s command again instead of replaying it", path, params.Mode)
+	}
+	params.Output, params.OutputDir = "", ""
+	return output, nil
+}
+
+// CommandLine renders the shint invocation equivalent to params, with every
+// setting that has a flag. The bearer token, which is only read from a file,
+// and the sequential mode of plans have none and are left out.
+func CommandLine(params InputParams) string {
+	args := []string{"shint"}
+	switch params.Mode {
+	case "telnet":
+		args = append(args, "telnet", params.Host, strconv.Itoa(params.FromPort))
+		if params.Send != "" {
+			args = append(args, "--send", strconv.Quote(escape(params.Send)))
+		}
+		if params.RandomPayload {
+			args = append(args, "--payload", strconv.Itoa(params.Payload))
+		}
+		if params.Expect != "" && params.ExpectRegex {
+			args = append(args, "--expect-regex", strconv.Quote(params.Expect))
+		} else if params.Expect != "" {
+			args = append(args, "--expect", strconv.Quote(params.Expect))
+		}
+		if params.Protocol == "udp" {
+			args = append(args, "--udp")
+		}
+	case "icmp":
+		args = append(args, "ping", params.Host)
+		if params.Protocol == PingTCP {
+			args = append(args, "--tcp", strconv.Itoa(params.FromPort))
+		}
+		// always given, 0 disables the fallback on by default
+		args = append(args, "--fallback-port", strconv.Itoa(params.FallbackPort))
+	case "web":
+		args = append(args, "web", params.URL)
+		if params.Method != "" && params.Method != "GET" {
+			args = append(args, "-X", params.Method)
+		}
+		for _, header := range params.Headers {
+			args = append(args, "-H", strconv.Quote(header))
+		}
+		if params.Data != "" {
+			args = append(args, "-P", strconv.Quote(params.Data))
+		}
+		for _, field := range params.Form {
+			args = append(args, "--form", strconv.Quote(field))
+		}
+		for _, part := range params.Multipart {
+			field := part.Name + "=" + part.Value
+			if part.Path != "" {
+				field = part.Name + "=@" + part.Path
+			}
+			args = append(args, "-F", strconv.Quote(field))
+		}
+		if params.WithBody {
+			args = append(args, "-W")
+		}
+		switch params.HTTPVersion {
+		case HTTP11:
+			args = append(args, "--http1.1")
+		case HTTP2:
+			args = append(args, "--http2")
+		case H2C:
+			args = append(args, "--h2c")
+		case HTTP3:
+			args = append(args, "--http3")
+		}
+		if params.Reuse {
+			args = append(args, "--reuse")
+		}
+		if params.Output != "" {
+			args = append(args, "-o", strconv.Quote(params.Output))
+		}
+		if params.OutputDir != "" {
+			args = append(args, "--output-dir", strconv.Quote(params.OutputDir))
+		}
+		if params.MaxBody > 0 {
+			args = append(args, "--max-body", strconv.FormatInt(params.MaxBody, 10))
+		}
+		if params.User != "" {
+			args = append(args, "-u", strconv.Quote(params.User))
+		}
+		if params.Digest {
+			args = append(args, "--digest")
+		}
+		if params.OAuth2TokenURL != "" {
+			args = append(args, "--oauth2-token-url", strconv.Quote(params.OAuth2TokenURL))
+		}
+		if params.OAuth2ClientID != "" {
+			args = append(args, "--oauth2-client-id", strconv.Quote(params.OAuth2ClientID))
+		}
+		if params.OAuth2ClientSecret != "" {
+			args = append(args, "--oauth2-client-secret", strconv.Quote(params.OAuth2ClientSecret))
+		}
+		for _, scope := range params.OAuth2Scopes {
+			args = append(args, "--oauth2-scope", strconv.Quote(scope))
+		}
+	case "nmap":
+		args = append(args, "nmap", params.Host, "--from", strconv.Itoa(params.FromPort), "--to", strconv.Itoa(params.ToPort))
+		if params.Protocol == "udp" {
+			args = append(args, "--udp")
+		}
+	case "trace":
+		args = append(args, "trace", params.Host)
+		if params.FromPort > 0 {
+			args = append(args, "--tcp", strconv.Itoa(params.FromPort))
+		}
+		if params.MaxHops > 0 {
+			args = append(args, "--max-hops", strconv.Itoa(params.MaxHops))
+		}
+	case "mtu":
+		args = append(args, "mtu", params.Host)
+		if params.MaxMTU > 0 {
+			args = append(args, "--max-mtu", strconv.Itoa(params.MaxMTU))
+		}
+	default:
+		args = append(args, params.Mode, params.Host)
+	}
+	if params.Mode == "trace" { // the count of trace is the number of probes per hop
+		args = append(args, "--queries", strconv.Itoa(params.Count))
+	} else {
+		args = append(args, "--count", strconv.Itoa(params.Count))
+	}
+	args = append(args, "--timeout", strconv.Itoa(params.Timeout))
+	if params.Mode != "nmap" && params.Mode != "mtu" {
+		args = append(args, "--delay", strconv.Itoa(params.Delay))
+	}
+	if params.Mode == "icmp" {
+		args = append(args, "--payload", strconv.Itoa(params.Payload))
+	}
+	if params.Throttle {
+		args = append(args, "--throttle")
+	}
+	return strings.Join(args, " ")
+}
+
+// escape writes value with the Go escape sequences the --send flag of telnet
+// interprets, so that control characters such as \r\n survive the command
+// line. Double quotes are left as they are, as --send reads them literally.
+func escape(value string) string {
+	quoted := strconv.Quote(value)
+	return strings.ReplaceAll(quoted[1:len(quoted)-1], `\"`, `"`)
+}
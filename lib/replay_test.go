@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadJSONOutputRedacted refuses to replay a web result whose credentials
+// were redacted, as they would be sent as is.
+func TestLoadJSONOutputRedacted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.json")
+	data, _ := json.Marshal(JSONOutput{ModuleName: "web", InputParams: InputParams{Mode: "web", URL: "https://example.com", User: "admin:secret"}.Redacted()})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadJSONOutput(path); err == nil || !strings.Contains(err.Error(), "redacted") {
+		t.Errorf("Expected the redacted credentials to be refused, got %v", err)
+	}
+}
+
+// TestLoadJSONOutputSchema1 reads results written before schema_version and
+// the url were recorded, with the timeout still labelled in milliseconds.
+func TestLoadJSONOutputSchema1(t *testing.T) {
+	tests := []struct {
+		name, data, host, url string
+		timeout               int
+	}{
+		{"icmp", `{"module_name":"icmp","input_params":{"host":"192.0.2.1","timeout_ms":3,"count":2},"stats":[{"address":"192.0.2.1","success":true,"time_taken_ms":9}]}`, "192.0.2.1", "", 3},
+		{"web", `{"module_name":"web","input_params":{"host":"example.com","timeout_ms":7},"stats":[{"url":"https://example.com/health","errors":[]}]}`, "example.com", "https://example.com/health", 7},
+		{"web without stats", `{"module_name":"web","input_params":{"host":"example.com"},"stats":[]}`, "example.com", "https://example.com", 0},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "output.json")
+		if err := os.WriteFile(path, []byte(test.data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		output, err := LoadJSONOutput(path)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		params := output.InputParams
+		if params.Mode != output.ModuleName || params.Host != test.host || params.URL != test.url || params.Timeout != test.timeout || params.LegacyTimeout != 0 {
+			t.Errorf("%s: unexpected input params %+v", test.name, params)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "empty.json")
+	if err := os.WriteFile(path, []byte(`{"module_name":"telnet","stats":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadJSONOutput(path); err == nil {
+		t.Error("Expected an error for a result without input_params")
+	}
+}
+
+// TestCommandLine renders the command of each module, with the flags that
+// only some modules take.
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		params InputParams
+		want   string
+	}{
+		{InputParams{Mode: "telnet", Host: "example.com", FromPort: 443, Count: 2, Timeout: 5, Delay: 10},
+			"shint telnet example.com 443 --count 2 --timeout 5 --delay 10"},
+		{InputParams{Mode: "icmp", Host: "192.0.2.1", Count: 4, Timeout: 1, Delay: 100, Payload: 56, Throttle: true},
+			"shint ping 192.0.2.1 --fallback-port 0 --count 4 --timeout 1 --delay 100 --payload 56 --throttle"},
+		{InputParams{Mode: "nmap", Host: "192.0.2.1", FromPort: 20, ToPort: 25, Count: 1, Timeout: 2},
+			"shint nmap 192.0.2.1 --from 20 --to 25 --count 1 --timeout 2"},
+		{InputParams{Mode: "web", URL: "https://example.com", Method: "GET", Count: 1, Timeout: 5},
+			"shint web https://example.com --count 1 --timeout 5 --delay 0"},
+		{InputParams{Mode: "web", URL: "https://example.com/api", Method: "POST", Headers: []string{"accept: application/json"}, Data: `{"a":1}`, WithBody: true, Count: 1, Timeout: 5},
+			`shint web https://example.com/api -X POST -H "accept: application/json" -P "{\"a\":1}" -W --count 1 --timeout 5 --delay 0`},
+		{InputParams{Mode: "web", URL: "https://example.com/upload", Method: "POST", Form: []string{"a=1"}, Multipart: []FormPart{{Name: "note", Value: "hi"}, {Name: "file", Path: "docs/report.pdf", Filename: "report.pdf"}}, Count: 1, Timeout: 5},
+			`shint web https://example.com/upload -X POST --form "a=1" -F "note=hi" -F "file=@docs/report.pdf" --count 1 --timeout 5 --delay 0`},
+		{InputParams{Mode: "trace", Host: "example.com", Count: 1, Timeout: 1},
+			"shint trace example.com --queries 1 --timeout 1 --delay 0"},
+		{InputParams{Mode: "telnet", Host: "example.com", FromPort: 6379, Send: "PING \"x\"\r\n", Expect: `^\+PONG`, ExpectRegex: true, Count: 1, Timeout: 5},
+			`shint telnet example.com 6379 --send "PING \"x\"\\r\\n" --expect-regex "^\\+PONG" --count 1 --timeout 5 --delay 0`},
+	}
+	for _, test := range tests {
+		if got := CommandLine(test.params); got != test.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.params.Mode, test.want, got)
+		}
+	}
+}
+
+// TestLoadJSONOutputLocalFiles refuses results that would upload local files
+// and drops the files they would write.
+func TestLoadJSONOutputLocalFiles(t *testing.T) {
+	for _, test := range []struct {
+		params  InputParams
+		refused bool
+	}{
+		{InputParams{Mode: "web", URL: "https://example.com", Method: "POST", Data: "@/etc/passwd"}, true},
+		{InputParams{Mode: "web", URL: "https://example.com", Method: "POST", Multipart: []FormPart{{Name: "file", Path: "/etc/passwd", Filename: "passwd"}}}, true},
+		{InputParams{Mode: "web", URL: "https://example.com", Output: "/root/.bashrc", OutputDir: "/root"}, false},
+	} {
+		path := filepath.Join(t.TempDir(), "web.json")
+		data, _ := json.Marshal(JSONOutput{ModuleName: "web", InputParams: test.params})
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		output, err := LoadJSONOutput(path)
+		if (err != nil) != test.refused {
+			t.Errorf("Expected refused to be %v for %+v, got %v", test.refused, test.params, err)
+		}
+		if err == nil && (output.InputParams.Output != "" || output.InputParams.OutputDir != "") {
+			t.Errorf("Expected the output files to be dropped, got %+v", output.InputParams)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+)
+
+// moduleStatsTypes lists the type of the stats of every module, the ones
+// JSONOutput.UnmarshalJSON decodes them into.
+var moduleStatsTypes = map[string]reflect.Type{
+	"telnet": reflect.TypeOf([]TelnetStats{}),
+	"web":    reflect.TypeOf([]WebStats{}),
+	"icmp":   reflect.TypeOf([]ICMPStats{}),
+	"nmap":   reflect.TypeOf([]NmapStats{}),
+	"trace":  reflect.TypeOf([]TraceStats{}),
+	"mtu":    reflect.TypeOf(MTUStats{}),
+}
+
+// JSONSchema returns the JSON Schema (draft 2020-12) of the JSON output,
+// generated from the lib types and their json tags: every field is required
+// unless it is omitted when empty, and the type of stats depends on the
+// module_name.
+func JSONSchema() map[string]any {
+	generator := schemaGenerator{defs: make(map[string]any)}
+	schema := generator.object(reflect.TypeOf(JSONOutput{}))
+	schema["properties"].(map[string]any)["schema_version"] = map[string]any{"type": "integer", "const": SchemaVersion}
+	modules := make([]any, 0, len(moduleStatsTypes))
+	for _, module := range []string{"telnet", "web", "icmp", "nmap", "trace", "mtu"} {
+		modules = append(modules, map[string]any{
+			"if":   map[string]any{"properties": map[string]any{"module_name": map[string]any{"const": module}}},
+			"then": map[string]any{"properties": map[string]any{"stats": generator.schema(moduleStatsTypes[module])}},
+		})
+	}
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "shint JSON output"
+	schema["allOf"] = modules
+	schema["$defs"] = generator.defs
+	return schema
+}
+
+// schemaGenerator maps Go types to JSON Schema, collecting the structs in
+// $defs so that each is described once.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// schema describes a value of type t. Slices, maps and pointers are nullable,
+// as encoding/json writes null for their zero value.
+func (generator *schemaGenerator) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{generator.schema(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.Struct:
+		if _, ok := generator.defs[t.Name()]; !ok {
+			generator.defs[t.Name()] = map[string]any{} // placeholder for recursive types
+			generator.defs[t.Name()] = generator.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": []any{"string", "null"}, "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": []any{"array", "null"}, "items": generator.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []any{"object", "null"}, "additionalProperties": generator.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{} // any value
+}
+
+// object describes the fields of struct t as encoding/json writes them.
+func (generator *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := make([]any, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct { // embedded fields are inlined
+			embedded := generator.object(field.Type)
+			for name, property := range embedded["properties"].(map[string]any) {
+				properties[name] = property
+			}
+			required = append(required, embedded["required"].([]any)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = generator.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
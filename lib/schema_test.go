@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestJSONSchemaPublished checks that the published schema document is the
+// one generated from the current types.
+func TestJSONSchemaPublished(t *testing.T) {
+	published, err := os.ReadFile("../schema/output.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := json.MarshalIndent(JSONSchema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(published)) != string(generated) {
+		t.Error("schema/output.schema.json is stale, regenerate it with: go run . schema > schema/output.schema.json")
+	}
+}
+
+// TestJSONSchemaMatchesOutput validates the output of every module against
+// the schema, and checks that every module in the schema decodes into its
+// stats type.
+func TestJSONSchemaMatchesOutput(t *testing.T) {
+	data, _ := json.Marshal(JSONSchema())
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	for module, statsType := range moduleStatsTypes {
+		stats := reflect.New(statsType).Elem()
+		if statsType.Kind() == reflect.Slice {
+			stats = reflect.MakeSlice(statsType, 1, 1)
+		}
+		data, err := json.Marshal(JSONOutput{ModuleName: module, Stats: stats.Interface(), Analysis: []PingAnalysis{{}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output JSONOutput
+		if err := json.Unmarshal(data, &output); err != nil || reflect.TypeOf(output.Stats) != statsType {
+			t.Errorf("Expected %s stats to decode into %s, got %T (%v)", module, statsType, output.Stats, err)
+		}
+		var generic any
+		json.Unmarshal(data, &generic)
+		if err := validateSchema(schema, schema, generic, ""); err != nil {
+			t.Errorf("%s output does not match the schema: %v", module, err)
+		}
+		for _, condition := range schema["allOf"].([]any) {
+			condition := condition.(map[string]any)
+			if condition["if"].(map[string]any)["properties"].(map[string]any)["module_name"].(map[string]any)["const"] != module {
+				continue
+			}
+			statsSchema := condition["then"].(map[string]any)["properties"].(map[string]any)["stats"]
+			if err := validateSchema(schema, statsSchema.(map[string]any), generic.(map[string]any)["stats"], "stats"); err != nil {
+				t.Errorf("%s stats do not match the schema: %v", module, err)
+			}
+		}
+	}
+}
+
+// TestSchemaVersionStamped checks that the documents embedding outputs carry
+// the schema_version too.
+func TestSchemaVersionStamped(t *testing.T) {
+	for _, document := range []any{PlanReport{}, NmapDiff{}, ReplayComparison{}, Notification{}} {
+		data, err := json.Marshal(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stamped struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		if err := json.Unmarshal(data, &stamped); err != nil || stamped.SchemaVersion != SchemaVersion {
+			t.Errorf("Expected %T to carry schema_version %d, got %s", document, SchemaVersion, data)
+		}
+	}
+}
+
+// validateSchema is a small validator for the keywords JSONSchema uses: it
+// checks types, required and unknown properties, items, $ref and anyOf.
+func validateSchema(root map[string]any, schema map[string]any, value any, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		return validateSchema(root, root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any), value, path)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, option := range anyOf {
+			if validateSchema(root, option.(map[string]any), value, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s matches none of the alternatives", path)
+	}
+	types := make([]string, 0)
+	switch kind := schema["type"].(type) {
+	case string:
+		types = append(types, kind)
+	case []any:
+		for _, name := range kind {
+			types = append(types, name.(string))
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	actual := map[bool]string{true: "null"}[value == nil]
+	switch value := value.(type) {
+	case string:
+		actual = "string"
+	case bool:
+		actual = "boolean"
+	case float64:
+		actual = "number"
+		if value == float64(int64(value)) && strings.Contains(strings.Join(types, " "), "integer") {
+			actual = "integer"
+		}
+	case []any:
+		actual = "array"
+		for i, element := range value {
+			if err := validateSchema(root, schema["items"].(map[string]any), element, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		actual = "object"
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range schema["required"].([]any) {
+			if _, ok := value[name.(string)]; !ok {
+				return fmt.Errorf("%s misses %s", path, name)
+			}
+		}
+		for name, property := range value {
+			if properties == nil {
+				continue
+			}
+			propertySchema, ok := properties[name]
+			if !ok {
+				return fmt.Errorf("%s has the undocumented field %s", path, name)
+			}
+			if err := validateSchema(root, propertySchema.(map[string]any), property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range types {
+		if name == actual {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is %s, expected %s", path, actual, strings.Join(types, " or "))
+}
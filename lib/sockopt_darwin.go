@@ -0,0 +1,9 @@
+package lib
+
+import "syscall"
+
+// setDontFragment sets the don't fragment bit on the packets sent on the
+// socket fd with the IP_DONTFRAG option (28), which syscall does not name.
+func setDontFragment(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, 28, 1)
+}
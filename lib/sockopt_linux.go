@@ -0,0 +1,9 @@
+package lib
+
+import "syscall"
+
+// setDontFragment sets the don't fragment bit on the packets sent on the
+// socket fd, which Linux does by enforcing path MTU discovery.
+func setDontFragment(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package lib
+
+import (
+	"errors"
+	"runtime"
+)
+
+// setDontFragment is not implemented on this system.
+func setDontFragment(fd uintptr) error {
+	return errors.New("setting the don't fragment bit is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package lib
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isPortUnreachable tells whether a UDP socket error reports an ICMP port
+// unreachable, which unix systems surface as a refused connection.
+func isPortUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isConnectionRefused tells whether a TCP connect was answered with a reset.
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// setTTL sets the time to live of the packets sent on the socket fd.
+func setTTL(fd uintptr, ttl int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// isMessageTooLong tells whether a send failed because the packet exceeds the
+// MTU known for the route.
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
@@ -0,0 +1,37 @@
+//go:build windows
+
+package lib
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isPortUnreachable tells whether a UDP socket error reports an ICMP port
+// unreachable, which Windows surfaces as WSAECONNRESET.
+func isPortUnreachable(err error) bool {
+	return errors.Is(err, syscall.Errno(10054))
+}
+
+// isConnectionRefused tells whether a TCP connect was answered with a reset,
+// which Windows reports as WSAECONNREFUSED.
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.Errno(10061))
+}
+
+// setTTL sets the time to live of the packets sent on the socket fd.
+func setTTL(fd uintptr, ttl int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+}
+
+// setDontFragment sets the don't fragment bit on the packets sent on the
+// socket fd with the IP_DONTFRAGMENT option (14).
+func setDontFragment(fd uintptr) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, 14, 1)
+}
+
+// isMessageTooLong tells whether a send failed because the packet exceeds the
+// MTU known for the route, which Windows reports as WSAEMSGSIZE.
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.Errno(10040))
+}
@@ -0,0 +1,72 @@
+package lib
+
+import "time"
+
+// Summary condenses the per-request stats of a JSONOutput into the figures
+// shared by every module: how many probes were made, how many succeeded and
+// the latency of each successful one.
+type Summary struct {
+	Sent      int
+	Succeeded int
+	Latencies []time.Duration
+}
+
+// SuccessRate returns the percentage of probes that succeeded, or 0 when
+// nothing was sent.
+func (summary Summary) SuccessRate() float64 {
+	if summary.Sent == 0 {
+		return 0
+	}
+	return float64(summary.Succeeded) * 100 / float64(summary.Sent)
+}
+
+// Summarize walks the typed stats of a module output. Nmap results carry no
+// latency, so only open ports count as succeeded for them.
+func Summarize(output JSONOutput) Summary {
+	summary := Summary{Latencies: make([]time.Duration, 0)}
+	add := func(success bool, timetaken time.Duration) {
+		summary.Sent++
+		if success {
+			summary.Succeeded++
+			summary.Latencies = append(summary.Latencies, timetaken)
+		}
+	}
+	switch stats := output.Stats.(type) {
+	case []TelnetStats:
+		for _, stat := range stats {
+			add(stat.Success, time.Duration(stat.TimeTaken)*time.Microsecond)
+		}
+	case []WebStats:
+		for _, stat := range stats {
+			add(stat.Success, time.Duration(stat.TimeTaken)*time.Microsecond)
+		}
+	case []ICMPStats:
+		for _, stat := range stats {
+			add(stat.Success, time.Duration(stat.TimeTaken)*time.Microsecond)
+		}
+	case []NmapStats:
+		for _, stat := range stats {
+			summary.Sent++
+			if stat.Success {
+				summary.Succeeded++
+			}
+		}
+	case MTUStats: // a discovery is a single check
+		summary.Sent = 1
+		if stats.Success {
+			summary.Succeeded = 1
+		}
+	case []TraceStats: // a trace succeeds as far as its queries reached the destination
+		if len(stats) > 0 {
+			last := stats[len(stats)-1]
+			summary.Sent = last.Sent
+			if last.Reached {
+				summary.Succeeded = last.Received
+				for _, rtt := range last.RTTs {
+					summary.Latencies = append(summary.Latencies, time.Duration(rtt)*time.Microsecond)
+				}
+			}
+		}
+	}
+	return summary
+}
@@ -0,0 +1,142 @@
+package lib
+
+import "bytes"
+
+// Telnet protocol bytes (RFC 854) and the options negotiated by TelnetCodec.
+const (
+	TelnetIAC  byte = 255 // interpret as command
+	TelnetDONT byte = 254
+	TelnetDO   byte = 253
+	TelnetWONT byte = 252
+	TelnetWILL byte = 251
+	TelnetSB   byte = 250 // subnegotiation begin
+	TelnetSE   byte = 240 // subnegotiation end
+
+	TelnetOptionEcho            byte = 1
+	TelnetOptionSuppressGoAhead byte = 3
+)
+
+const (
+	telnetStateData = iota
+	telnetStateCommand
+	telnetStateOption
+	telnetStateSubnegotiation
+	telnetStateSubnegotiationCommand
+)
+
+// TelnetCodec strips Telnet commands from a received stream and answers the
+// option negotiation of the server, so that a plain TCP client can talk to a
+// real telnet daemon. It lets the server echo and suppress go-ahead, which is
+// what character-at-a-time servers expect, and refuses every other option.
+// Commands split across reads are handled, the codec keeps its state, and
+// the state of every option too so that a request is only answered when it
+// changes the option (RFC 1143), which keeps negotiation from looping.
+type TelnetCodec struct {
+	state   int
+	command byte
+	remote  [256]bool // options enabled on the side of the server
+	local   [256]bool // options enabled on our side
+}
+
+// Decode returns the data bytes of in and the negotiation replies to write
+// back to the server.
+func (codec *TelnetCodec) Decode(in []byte) (data []byte, reply []byte) {
+	data = make([]byte, 0, len(in))
+	for _, b := range in {
+		switch codec.state {
+		case telnetStateData:
+			if b == TelnetIAC {
+				codec.state = telnetStateCommand
+			} else {
+				data = append(data, b)
+			}
+		case telnetStateCommand:
+			switch b {
+			case TelnetIAC: // escaped 255 data byte
+				data = append(data, b)
+				codec.state = telnetStateData
+			case TelnetDO, TelnetDONT, TelnetWILL, TelnetWONT:
+				codec.command = b
+				codec.state = telnetStateOption
+			case TelnetSB:
+				codec.state = telnetStateSubnegotiation
+			default: // NOP, GA, AYT and friends carry no option
+				codec.state = telnetStateData
+			}
+		case telnetStateOption:
+			reply = append(reply, codec.answer(codec.command, b)...)
+			codec.state = telnetStateData
+		case telnetStateSubnegotiation:
+			if b == TelnetIAC {
+				codec.state = telnetStateSubnegotiationCommand
+			}
+		case telnetStateSubnegotiationCommand:
+			if b == TelnetSE {
+				codec.state = telnetStateData
+			} else {
+				codec.state = telnetStateSubnegotiation
+			}
+		}
+	}
+	return data, reply
+}
+
+// answer accepts the server echoing and suppressing go-ahead and refuses
+// everything else. As the codec never asks for an option itself, a request
+// that matches the current state of the option is ignored and only changes
+// are answered, acknowledging a disabled option as RFC 1143 requires.
+func (codec *TelnetCodec) answer(command byte, option byte) []byte {
+	switch command {
+	case TelnetWILL:
+		if codec.remote[option] {
+			return nil
+		}
+		if option == TelnetOptionEcho || option == TelnetOptionSuppressGoAhead {
+			codec.remote[option] = true
+			return []byte{TelnetIAC, TelnetDO, option}
+		}
+		return []byte{TelnetIAC, TelnetDONT, option}
+	case TelnetWONT:
+		if !codec.remote[option] {
+			return nil
+		}
+		codec.remote[option] = false
+		return []byte{TelnetIAC, TelnetDONT, option}
+	case TelnetDO:
+		if codec.local[option] {
+			return nil
+		}
+		if option == TelnetOptionSuppressGoAhead {
+			codec.local[option] = true
+			return []byte{TelnetIAC, TelnetWILL, option}
+		}
+		return []byte{TelnetIAC, TelnetWONT, option}
+	case TelnetDONT:
+		if !codec.local[option] {
+			return nil
+		}
+		codec.local[option] = false
+		return []byte{TelnetIAC, TelnetWONT, option}
+	}
+	return nil
+}
+
+// Encode escapes the 255 bytes of data sent to a telnet server.
+func (codec *TelnetCodec) Encode(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{TelnetIAC}, []byte{TelnetIAC, TelnetIAC})
+}
+
+// TranslateCRLF turns bare line feeds into the CR LF line endings that most
+// line based network protocols expect. lastCR tells whether the previous
+// chunk ended with a carriage return, and the updated value is returned.
+func TranslateCRLF(data []byte, lastCR bool) ([]byte, bool) {
+	out := make([]byte, 0, len(data)+8)
+	for _, b := range data {
+		if b == '\n' && !lastCR {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+		lastCR = b == '\r'
+	}
+	return out, lastCR
+}
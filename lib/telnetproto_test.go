@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestTelnetCodec checks negotiation replies, escaping and commands split
+// across reads.
+func TestTelnetCodec(t *testing.T) {
+	codec := TelnetCodec{}
+	in := []byte{'h', 'i', TelnetIAC, TelnetWILL, TelnetOptionEcho, TelnetIAC, TelnetDO, 24, TelnetIAC, TelnetIAC, TelnetIAC, TelnetSB, 24, 1, TelnetIAC, TelnetSE, '!', TelnetIAC}
+	data, reply := codec.Decode(in)
+	if !bytes.Equal(data, []byte{'h', 'i', TelnetIAC, '!'}) {
+		t.Errorf("Unexpected data %v", data)
+	}
+	if !bytes.Equal(reply, []byte{TelnetIAC, TelnetDO, TelnetOptionEcho, TelnetIAC, TelnetWONT, 24}) {
+		t.Errorf("Unexpected reply %v", reply)
+	}
+	// the trailing IAC continues in the next read
+	data, reply = codec.Decode([]byte{TelnetWILL, TelnetOptionSuppressGoAhead, 'o', 'k'})
+	if !bytes.Equal(data, []byte("ok")) || !bytes.Equal(reply, []byte{TelnetIAC, TelnetDO, TelnetOptionSuppressGoAhead}) {
+		t.Errorf("Unexpected split command handling, data %v reply %v", data, reply)
+	}
+	if encoded := codec.Encode([]byte{1, TelnetIAC, 2}); !bytes.Equal(encoded, []byte{1, TelnetIAC, TelnetIAC, 2}) {
+		t.Errorf("Unexpected encoding %v", encoded)
+	}
+}
+
+// TestTranslateCRLF checks that only bare line feeds are translated.
+func TestTranslateCRLF(t *testing.T) {
+	out, lastCR := TranslateCRLF([]byte("a\nb\r"), false)
+	if string(out) != "a\r\nb\r" || !lastCR {
+		t.Errorf("Unexpected translation %q", out)
+	}
+	if out, _ = TranslateCRLF([]byte("\nc\n"), lastCR); string(out) != "\nc\r\n" {
+		t.Errorf("Unexpected translation across chunks %q", out)
+	}
+}
+
+// TestTelnetCodecOptionState checks that requests which do not change the
+// state of an option are ignored, so that negotiation cannot loop.
+func TestTelnetCodecOptionState(t *testing.T) {
+	codec := TelnetCodec{}
+	for _, test := range []struct {
+		in, reply []byte
+	}{
+		{[]byte{TelnetIAC, TelnetWILL, TelnetOptionEcho}, []byte{TelnetIAC, TelnetDO, TelnetOptionEcho}},
+		{[]byte{TelnetIAC, TelnetWILL, TelnetOptionEcho}, nil},
+		{[]byte{TelnetIAC, TelnetDO, TelnetOptionSuppressGoAhead}, []byte{TelnetIAC, TelnetWILL, TelnetOptionSuppressGoAhead}},
+		{[]byte{TelnetIAC, TelnetDO, TelnetOptionSuppressGoAhead}, nil},
+		{[]byte{TelnetIAC, TelnetWONT, TelnetOptionEcho}, []byte{TelnetIAC, TelnetDONT, TelnetOptionEcho}},
+		{[]byte{TelnetIAC, TelnetWONT, TelnetOptionEcho}, nil},
+		{[]byte{TelnetIAC, TelnetDONT, TelnetOptionSuppressGoAhead}, []byte{TelnetIAC, TelnetWONT, TelnetOptionSuppressGoAhead}},
+		{[]byte{TelnetIAC, TelnetDONT, TelnetOptionSuppressGoAhead}, nil},
+		{[]byte{TelnetIAC, TelnetWILL, TelnetOptionEcho}, []byte{TelnetIAC, TelnetDO, TelnetOptionEcho}},
+	} {
+		if _, reply := codec.Decode(test.in); !bytes.Equal(reply, test.reply) {
+			t.Errorf("Expected the reply %v to %v, got %v", test.reply, test.in, reply)
+		}
+	}
+}
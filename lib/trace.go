@@ -0,0 +1,344 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// TraceOptions controls a path discovery.
+type TraceOptions struct {
+	MaxHops int           // highest TTL probed
+	Queries int           // rounds of probes, one probe per hop and round
+	Port    int           // TCP port traced with SYNs, 0 traces with ICMP echo requests
+	Timeout time.Duration // how long a round waits for the answers
+	Payload int           // bytes of data in the ICMP echo requests
+}
+
+// traceAnswer is an answer to the probe sent with a TTL in a round.
+type traceAnswer struct {
+	ttl     int
+	from    string
+	rtt     time.Duration
+	reached bool // the destination answered rather than a router on the way
+}
+
+// traceICMP is an ICMP message read from the raw socket, reduced to what is
+// needed to match it with a probe.
+type traceICMP struct {
+	at      time.Time
+	from    string
+	reached bool   // echo reply or unreachable sent by the destination
+	id, seq int    // of the echo request that was answered or quoted
+	port    int    // source port of the TCP SYN quoted by a router
+	proto   int    // protocol of the quoted packet
+	target  net.IP // destination of the quoted packet
+}
+
+// Trace discovers the path to destination by sending probes with increasing
+// TTLs and listening for the ICMP time exceeded answers of the routers on the
+// way, mtr style: every round probes all hops at once, for Queries rounds.
+// Routers are only heard on a raw ICMP socket, which needs root or the
+// CAP_NET_RAW capability. The hops up to the destination are returned.
+func Trace(ctx context.Context, destination net.IP, options TraceOptions) ([]TraceStats, error) {
+	destination = destination.To4()
+	if destination == nil {
+		return nil, errors.New("trace only supports IPv4 destinations")
+	}
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("trace needs a raw ICMP socket, run it as root or with the CAP_NET_RAW capability: %w", err)
+	}
+	defer conn.Close()
+	messages, done := make(chan traceICMP, 64), make(chan struct{})
+	defer close(done)
+	go readTraceICMP(conn, destination, messages, done)
+
+	hops := make([]TraceStats, options.MaxHops)
+	for i := range hops {
+		hops[i].TTL = i + 1
+	}
+	id := rand.IntN(0xffff)
+	last := options.MaxHops // lowered to the hop of the destination once it answered
+	for round := 0; round < options.Queries && ctx.Err() == nil; round++ {
+		if round > 0 {
+			Sleep(ctx, time.Second)
+		}
+		var answers []traceAnswer
+		if options.Port > 0 {
+			answers = traceTCPRound(ctx, messages, destination, options, last)
+		} else {
+			answers = traceICMPRound(ctx, conn, messages, destination, options, id, round, last)
+		}
+		for ttl := 1; ttl <= last; ttl++ {
+			hops[ttl-1].Sent++
+		}
+		for _, answer := range answers {
+			if answer.ttl > last {
+				continue
+			}
+			hop := &hops[answer.ttl-1]
+			hop.Received++
+			hop.RTTs = append(hop.RTTs, answer.rtt.Microseconds())
+			if hop.Address == "" {
+				hop.Address = answer.from
+			}
+			if !strings.Contains(" "+strings.Join(hop.Addresses, " ")+" ", " "+answer.from+" ") {
+				hop.Addresses = append(hop.Addresses, answer.from)
+			}
+			if answer.reached {
+				hop.Reached = true
+				last = answer.ttl
+			}
+		}
+	}
+	hops = hops[:last]
+	for i := range hops {
+		hops[i].measure()
+	}
+	resolveTraceHops(ctx, hops)
+	return hops, nil
+}
+
+// readTraceICMP reads the raw ICMP socket until it is closed and forwards the
+// answers concerning destination until done is closed.
+func readTraceICMP(conn *icmp.PacketConn, destination net.IP, messages chan<- traceICMP, done <-chan struct{}) {
+	buffer := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buffer)
+		if err != nil {
+			close(messages)
+			return
+		}
+		at := time.Now()
+		message, err := icmp.ParseMessage(1, buffer[:n]) // 1 is ICMP for IPv4
+		if err != nil {
+			continue
+		}
+		from := peer.String()
+		var answer traceICMP
+		var ok bool
+		switch body := message.Body.(type) {
+		case *icmp.Echo:
+			answer = traceICMP{at: at, from: from, reached: true, id: body.ID, seq: body.Seq, proto: 1, target: destination}
+			ok = message.Type == ipv4.ICMPTypeEchoReply && from == destination.String()
+		case *icmp.TimeExceeded:
+			answer, ok = parseQuoted(body.Data, at, from)
+			ok = ok && answer.target.Equal(destination)
+		case *icmp.DstUnreach:
+			answer, ok = parseQuoted(body.Data, at, from)
+			ok = ok && answer.target.Equal(destination)
+			answer.reached = from == destination.String()
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case messages <- answer:
+		case <-done: // the trace returned, nobody reads the answers anymore
+			return
+		}
+	}
+}
+
+// parseQuoted reads the IP header and first bytes of the probe quoted by an
+// ICMP error to find out which probe it answers.
+func parseQuoted(data []byte, at time.Time, from string) (traceICMP, bool) {
+	if len(data) < 20 {
+		return traceICMP{}, false
+	}
+	length := int(data[0]&0x0f) * 4
+	if len(data) < length+8 {
+		return traceICMP{}, false
+	}
+	quoted := traceICMP{at: at, from: from, proto: int(data[9]), target: net.IP(data[16:20])}
+	payload := data[length:]
+	switch quoted.proto {
+	case 1:
+		quoted.id, quoted.seq = int(binary.BigEndian.Uint16(payload[4:6])), int(binary.BigEndian.Uint16(payload[6:8]))
+	case 6:
+		quoted.port = int(binary.BigEndian.Uint16(payload[0:2]))
+	}
+	return quoted, true
+}
+
+// traceICMPRound sends one echo request per TTL up to last and collects the
+// answers until they all arrived or the round timed out. The sequence number
+// carries the round and the TTL.
+func traceICMPRound(ctx context.Context, conn *icmp.PacketConn, messages <-chan traceICMP, destination net.IP, options TraceOptions, id int, round int, last int) []traceAnswer {
+	sent := make(map[int]time.Time)
+	for ttl := 1; ttl <= last; ttl++ {
+		seq := (round&0xff)<<8 | ttl
+		request, err := (&icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: make([]byte, options.Payload)},
+		}).Marshal(nil)
+		if err != nil || conn.IPv4PacketConn().SetTTL(ttl) != nil {
+			continue
+		}
+		sent[seq] = time.Now()
+		if _, err := conn.WriteTo(request, &net.IPAddr{IP: destination}); err != nil {
+			delete(sent, seq)
+		}
+	}
+	return collectTraceAnswers(ctx, messages, options.Timeout, len(sent), func(message traceICMP) (traceAnswer, bool) {
+		at, ok := sent[message.seq]
+		if message.proto != 1 || message.id != id || !ok {
+			return traceAnswer{}, false
+		}
+		delete(sent, message.seq)
+		return traceAnswer{ttl: message.seq & 0xff, from: message.from, rtt: message.at.Sub(at), reached: message.reached}, true
+	})
+}
+
+// traceTCPRound starts one TCP connection per TTL up to last, each from its
+// own source port so that the SYN quoted by a router identifies the TTL. A
+// completed or refused connection means the destination was reached.
+func traceTCPRound(ctx context.Context, messages <-chan traceICMP, destination net.IP, options TraceOptions, last int) []traceAnswer {
+	roundctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+	type probe struct {
+		ttl  int
+		sent time.Time
+	}
+	var MUTEX sync.Mutex
+	probes := make(map[int]probe)
+	connected := make(chan traceICMP, last)
+	base := 33000 + rand.IntN(25000)
+	for ttl := 1; ttl <= last; ttl++ {
+		port := base + ttl
+		MUTEX.Lock()
+		probes[port] = probe{ttl: ttl, sent: time.Now()}
+		MUTEX.Unlock()
+		go func(ttl int, port int) {
+			dialer := net.Dialer{
+				LocalAddr: &net.TCPAddr{Port: port},
+				Control: func(network, address string, raw syscall.RawConn) error {
+					var err error
+					if cerr := raw.Control(func(fd uintptr) { err = setTTL(fd, ttl) }); cerr != nil {
+						return cerr
+					}
+					return err
+				},
+			}
+			conn, err := dialer.DialContext(roundctx, "tcp4", net.JoinHostPort(destination.String(), strconv.Itoa(options.Port)))
+			if err == nil {
+				conn.Close()
+			}
+			if err == nil || isConnectionRefused(err) {
+				connected <- traceICMP{at: time.Now(), from: destination.String(), reached: true, port: port, proto: 6}
+			}
+		}(ttl, port)
+	}
+	merged := make(chan traceICMP, last)
+	go func() {
+		for {
+			var message traceICMP
+			var ok bool
+			select {
+			case message, ok = <-messages:
+				if !ok {
+					return
+				}
+			case message = <-connected:
+			case <-roundctx.Done():
+				return
+			}
+			select {
+			case merged <- message:
+			case <-roundctx.Done():
+				return
+			}
+		}
+	}()
+	return collectTraceAnswers(roundctx, merged, options.Timeout, last, func(message traceICMP) (traceAnswer, bool) {
+		MUTEX.Lock()
+		defer MUTEX.Unlock()
+		probe, ok := probes[message.port]
+		if message.proto != 6 || !ok {
+			return traceAnswer{}, false
+		}
+		delete(probes, message.port)
+		return traceAnswer{ttl: probe.ttl, from: message.from, rtt: message.at.Sub(probe.sent), reached: message.reached}, true
+	})
+}
+
+// collectTraceAnswers reads messages until expected answers were matched, the
+// timeout elapsed or ctx was cancelled.
+func collectTraceAnswers(ctx context.Context, messages <-chan traceICMP, timeout time.Duration, expected int, match func(traceICMP) (traceAnswer, bool)) []traceAnswer {
+	answers := make([]traceAnswer, 0, expected)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for len(answers) < expected {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return answers
+			}
+			if answer, matched := match(message); matched {
+				answers = append(answers, answer)
+			}
+		case <-timer.C:
+			return answers
+		case <-ctx.Done():
+			return answers
+		}
+	}
+	return answers
+}
+
+// measure computes the loss and latency figures of a hop from its answers.
+func (hop *TraceStats) measure() {
+	hop.Success = hop.Received > 0
+	if hop.Sent > 0 {
+		hop.Loss = float64(hop.Sent-hop.Received) * 100 / float64(hop.Sent)
+	}
+	if len(hop.RTTs) == 0 {
+		return
+	}
+	hop.Min, hop.Max = hop.RTTs[0], hop.RTTs[0]
+	var sum int64
+	for _, rtt := range hop.RTTs {
+		hop.Min, hop.Max = min(hop.Min, rtt), max(hop.Max, rtt)
+		sum += rtt
+	}
+	hop.Avg = sum / int64(len(hop.RTTs))
+	var variance float64
+	for _, rtt := range hop.RTTs {
+		variance += float64(rtt-hop.Avg) * float64(rtt-hop.Avg)
+	}
+	hop.StdDev = int64(math.Sqrt(variance / float64(len(hop.RTTs))))
+}
+
+// resolveTraceHops fills in the reverse DNS name of every hop, looking the
+// addresses up concurrently.
+func resolveTraceHops(ctx context.Context, hops []TraceStats) {
+	var WG sync.WaitGroup
+	for i := range hops {
+		if hops[i].Address == "" {
+			continue
+		}
+		WG.Add(1)
+		go func(hop *TraceStats) {
+			defer WG.Done()
+			lookupctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+			defer cancel()
+			if names, err := net.DefaultResolver.LookupAddr(lookupctx, hop.Address); err == nil && len(names) > 0 {
+				hop.Hostname = strings.TrimSuffix(names[0], ".")
+			}
+		}(&hops[i])
+	}
+	WG.Wait()
+}
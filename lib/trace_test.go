@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestParseQuoted finds the echo request and the TCP SYN quoted by time
+// exceeded messages.
+func TestParseQuoted(t *testing.T) {
+	header := []byte{0x45, 0, 0, 28, 0, 0, 0, 0, 1, 1, 0, 0, 10, 0, 0, 1, 192, 0, 2, 7}
+	echo := append(append([]byte{}, header...), 8, 0, 0, 0, 0x12, 0x34, 0x02, 0x05)
+	quoted, ok := parseQuoted(echo, time.Now(), "10.0.0.254")
+	if !ok || quoted.proto != 1 || quoted.id != 0x1234 || quoted.seq != 0x0205 || !quoted.target.Equal(net.IPv4(192, 0, 2, 7)) {
+		t.Errorf("Unexpected quoted echo request %+v", quoted)
+	}
+	header[9] = 6
+	syn := append(append([]byte{}, header...), 0x80, 0xe8, 0x01, 0xbb, 0, 0, 0, 0)
+	if quoted, ok = parseQuoted(syn, time.Now(), "10.0.0.254"); !ok || quoted.proto != 6 || quoted.port != 33000 {
+		t.Errorf("Unexpected quoted SYN %+v", quoted)
+	}
+	if _, ok = parseQuoted(header[:12], time.Now(), "10.0.0.254"); ok {
+		t.Error("Expected a truncated packet to be rejected")
+	}
+}
+
+// TestTraceHopSummary computes the loss and latency of a hop and summarizes a
+// trace from the hop of the destination.
+func TestTraceHopSummary(t *testing.T) {
+	hop := TraceStats{TTL: 2, Reached: true, Sent: 4, Received: 2, RTTs: []int64{1000, 3000}}
+	hop.measure()
+	if !hop.Success || hop.Loss != 50 || hop.Min != 1000 || hop.Max != 3000 || hop.Avg != 2000 || hop.StdDev != 1000 {
+		t.Errorf("Unexpected hop figures %+v", hop)
+	}
+	summary := Summarize(JSONOutput{Stats: []TraceStats{{TTL: 1, Sent: 4, Received: 4}, hop}})
+	if summary.Sent != 4 || summary.Succeeded != 2 || len(summary.Latencies) != 2 {
+		t.Errorf("Unexpected trace summary %+v", summary)
+	}
+}
+
+// TestTraceMaxHops checks that plans cannot ask for hops the TTL cannot carry.
+func TestTraceMaxHops(t *testing.T) {
+	for hops, valid := range map[int]bool{-1: false, 0: true, 1: true, 255: true, 256: false} {
+		params := InputParams{Mode: "trace", Host: "192.0.2.1", MaxHops: hops}
+		if err := params.Normalize(); (err == nil) != valid {
+			t.Errorf("Expected max_hops %d to be valid: %t, got %v", hops, valid, err)
+		}
+	}
+}
+
+// TestTraceLoopback traces to the loopback address, which answers at the first
+// hop. It needs a raw socket and is skipped without one.
+func TestTraceLoopback(t *testing.T) {
+	hops, err := Trace(context.Background(), net.IPv4(127, 0, 0, 1), TraceOptions{MaxHops: 5, Queries: 1, Timeout: time.Second})
+	if err != nil {
+		t.Skip(err)
+	}
+	if len(hops) != 1 || !hops[0].Reached || hops[0].Address != "127.0.0.1" {
+		t.Errorf("Expected the loopback address to be reached at the first hop, got %+v", hops)
+	}
+}
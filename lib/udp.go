@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States a UDP port is classified in. Without a reply a UDP port cannot be told
+// apart from a firewall silently dropping the datagram, hence open|filtered.
+const (
+	UDPOpen         = "open"
+	UDPOpenFiltered = "open|filtered"
+	UDPClosed       = "closed"
+)
+
+// udpPayloads holds requests that make the services usually found on a port
+// answer: a DNS query for the root name servers, an NTP v3 client request and
+// an SNMP v1 get of sysDescr.0 with the public community.
+var udpPayloads = map[int]string{
+	53:  "123401000001000000000000" + "0000020001",
+	123: "1b" + strings.Repeat("00", 47),
+	161: "302602010004067075626c6963a019020101020100020100300e300c06082b060102010101000500",
+}
+
+// UDPPayload returns the protocol aware request for port, or an empty
+// datagram for ports without a known service.
+func UDPPayload(port int) []byte {
+	payload, _ := hex.DecodeString(udpPayloads[port])
+	return payload
+}
+
+// ProbeUDP sends payload to ip:port and waits up to timeout for a reply. The
+// port is open when anything comes back and closed when the ICMP port
+// unreachable error is surfaced by the socket. Other errors are returned.
+func ProbeUDP(ip string, port int, payload []byte, timeout time.Duration) (string, []byte, time.Duration, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", nil, 0, err
+	}
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		if isPortUnreachable(err) {
+			return UDPClosed, nil, time.Since(start), nil
+		}
+		return "", nil, 0, err
+	}
+	buffer := make([]byte, 64*1024)
+	n, err := conn.Read(buffer)
+	rtt := time.Since(start)
+	var timeoutErr net.Error
+	switch {
+	case err == nil:
+		return UDPOpen, buffer[:n], rtt, nil
+	case isPortUnreachable(err):
+		return UDPClosed, nil, rtt, nil
+	case errors.As(err, &timeoutErr) && timeoutErr.Timeout():
+		return UDPOpenFiltered, nil, rtt, nil
+	}
+	return "", nil, rtt, err
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestProbeUDP classifies a loopback port with a responder as open and a port
+// without one as closed from the ICMP port unreachable.
+func TestProbeUDP(t *testing.T) {
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responder.Close()
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, addr, err := responder.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			responder.WriteTo(buffer[:n], addr)
+		}
+	}()
+	port := responder.LocalAddr().(*net.UDPAddr).Port
+	state, response, _, err := ProbeUDP("127.0.0.1", port, UDPPayload(53), time.Second)
+	if err != nil || state != UDPOpen || len(response) != 17 {
+		t.Errorf("Expected an open port echoing the DNS query, got %s %v %v", state, response, err)
+	}
+
+	unused, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port = unused.LocalAddr().(*net.UDPAddr).Port
+	unused.Close()
+	if state, _, _, err = ProbeUDP("127.0.0.1", port, nil, time.Second); err != nil || state != UDPClosed {
+		t.Errorf("Expected a closed port, got %s %v", state, err)
+	}
+}
@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// RedactedValue replaces the credentials in the JSON output.
+const RedactedValue = "[redacted]"
+
+// sensitiveHeaders carry credentials and are redacted from the output.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// Redacted returns params with the credentials replaced by RedactedValue, to
+// be written in the output.
+func (params InputParams) Redacted() InputParams {
+	if user, _, ok := strings.Cut(params.User, ":"); ok {
+		params.User = user + ":" + RedactedValue
+	}
+	if params.Bearer != "" {
+		params.Bearer = RedactedValue
+	}
+	if params.OAuth2ClientSecret != "" {
+		params.OAuth2ClientSecret = RedactedValue
+	}
+	headers := make([]string, 0, len(params.Headers))
+	for _, header := range params.Headers {
+		name, _, _ := strings.Cut(header, ":")
+		for _, sensitive := range sensitiveHeaders {
+			if strings.EqualFold(strings.TrimSpace(name), sensitive) {
+				header = name + ": " + RedactedValue
+			}
+		}
+		headers = append(headers, header)
+	}
+	if params.Headers != nil {
+		params.Headers = headers
+	}
+	return params
+}
+
+// IsRedacted tells whether params hold credentials replaced by Redacted,
+// which cannot be sent again.
+func (params InputParams) IsRedacted() bool {
+	if strings.HasSuffix(params.User, ":"+RedactedValue) || params.Bearer == RedactedValue || params.OAuth2ClientSecret == RedactedValue {
+		return true
+	}
+	for _, header := range params.Headers {
+		name, value, _ := strings.Cut(header, ":")
+		if strings.TrimSpace(value) == RedactedValue && slices.ContainsFunc(sensitiveHeaders, func(sensitive string) bool {
+			return strings.EqualFold(strings.TrimSpace(name), sensitive)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeader returns a copy of header with the values of the credentials
+// replaced by RedactedValue.
+func RedactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, RedactedValue)
+		}
+	}
+	return redacted
+}
+
+// OAuth2Token is the answer of an OAuth2 token endpoint.
+type OAuth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// FetchOAuth2Token gets an access token from the OAuth2 token endpoint of
+// params with the client credentials grant of RFC 6749 section 4.4, the
+// client authenticating with HTTP basic authentication.
+func FetchOAuth2Token(ctx context.Context, client *http.Client, params InputParams) (OAuth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(params.OAuth2Scopes) > 0 {
+		form.Set("scope", strings.Join(params.OAuth2Scopes, " "))
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, params.OAuth2TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OAuth2Token{}, err
+	}
+	request.Header.Set("content-type", "application/x-www-form-urlencoded")
+	request.Header.Set("accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(params.OAuth2ClientID), url.QueryEscape(params.OAuth2ClientSecret))
+	response, err := client.Do(request)
+	if err != nil {
+		return OAuth2Token{}, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return OAuth2Token{}, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return OAuth2Token{}, fmt.Errorf("OAuth2 token endpoint returned %s: %s", response.Status, strings.TrimSpace(string(body[:min(len(body), 200)])))
+	}
+	var token OAuth2Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return OAuth2Token{}, fmt.Errorf("invalid OAuth2 token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return OAuth2Token{}, fmt.Errorf("OAuth2 token endpoint returned no access_token")
+	}
+	return token, nil
+}
+
+// DigestAuthorization answers the Digest challenge of a WWW-Authenticate
+// header for a request to uri, as described by RFC 7616. The MD5 and SHA-256
+// algorithms are supported, with the auth quality of protection when the
+// server offers it.
+func DigestAuthorization(challenge string, method string, uri string, user string, password string) (string, error) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("the server did not ask for digest authentication but for '%s'", scheme)
+	}
+	fields := parseAuthParams(rest)
+	var newHash func() hash.Hash
+	switch algorithm := strings.ToUpper(fields["algorithm"]); algorithm {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm '%s'", fields["algorithm"])
+	}
+	digest := func(parts ...string) string {
+		h := newHash()
+		h.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	ha1 := digest(user, fields["realm"], password)
+	ha2 := digest(method, uri)
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, user, fields["realm"], fields["nonce"], uri)
+	qop := ""
+	for _, offered := range strings.Split(fields["qop"], ",") {
+		if strings.TrimSpace(offered) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		nonce := make([]byte, 8)
+		rand.Read(nonce)
+		cnonce := hex.EncodeToString(nonce)
+		header += fmt.Sprintf(`, qop=auth, nc=00000001, cnonce="%s", response="%s"`, cnonce, digest(ha1, fields["nonce"], "00000001", cnonce, qop, ha2))
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, digest(ha1, fields["nonce"], ha2))
+	}
+	if fields["algorithm"] != "" {
+		header += ", algorithm=" + fields["algorithm"]
+	}
+	if fields["opaque"] != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, fields["opaque"])
+	}
+	return header, nil
+}
+
+// parseAuthParams splits the comma separated name=value parameters of a
+// challenge, the values being quoted or not.
+func parseAuthParams(params string) map[string]string {
+	fields := make(map[string]string)
+	for params != "" {
+		name, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(strings.TrimLeft(name, ", ")))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			value, rest = rest[1:end+1], rest[min(end+2, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		fields[name] = strings.TrimSpace(value)
+		params = strings.TrimLeft(rest, ", ")
+	}
+	return fields
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRedacted checks that the credentials are replaced in params and
+// headers, and left out of nothing else.
+func TestRedacted(t *testing.T) {
+	params := InputParams{
+		User:               "admin:secret",
+		Bearer:             "token",
+		OAuth2ClientID:     "client",
+		OAuth2ClientSecret: "secret",
+		Headers:            []string{"Authorization: Bearer token", "cookie:session=1", "X-Request-Id: 42"},
+	}
+	redacted := params.Redacted()
+	expected := InputParams{
+		User:               "admin:" + RedactedValue,
+		Bearer:             RedactedValue,
+		OAuth2ClientID:     "client",
+		OAuth2ClientSecret: RedactedValue,
+		Headers:            []string{"Authorization: " + RedactedValue, "cookie: " + RedactedValue, "X-Request-Id: 42"},
+	}
+	if !reflect.DeepEqual(redacted, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, redacted)
+	}
+	if params.Headers[0] != "Authorization: Bearer token" {
+		t.Error("Expected the params themselves to be left untouched")
+	}
+	if params.IsRedacted() || !redacted.IsRedacted() || !(InputParams{Headers: []string{"cookie: " + RedactedValue}}).IsRedacted() {
+		t.Error("Expected only the redacted params to be told apart")
+	}
+	header := http.Header{"Authorization": {"Basic YWRtaW46c2VjcmV0"}, "Accept": {"*/*"}}
+	if redacted := RedactHeader(header); redacted.Get("Authorization") != RedactedValue || redacted.Get("Accept") != "*/*" || header.Get("Authorization") == RedactedValue {
+		t.Errorf("Expected only the authorization of a copy to be redacted, got %v", redacted)
+	}
+}
+
+// TestDigestAuthorization answers the MD5 challenge of the example of RFC 7616
+// section 3.9.1 and checks the response for the client nonce it picked.
+func TestDigestAuthorization(t *testing.T) {
+	challenge := `Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=MD5, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+	authorization, err := DigestAuthorization(challenge, "GET", "/dir/index.html", "Mufasa", "Circle of Life")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := parseAuthParams(strings.TrimPrefix(authorization, "Digest "))
+	md5hex := func(value string) string {
+		sum := md5.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := md5hex("Mufasa:http-auth@example.org:Circle of Life")
+	expected := md5hex(ha1 + ":7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v:00000001:" + fields["cnonce"] + ":auth:" + md5hex("GET:/dir/index.html"))
+	if fields["response"] != expected || fields["qop"] != "auth" || fields["opaque"] != "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS" || fields["username"] != "Mufasa" {
+		t.Errorf("Expected the response %s, got %s", expected, authorization)
+	}
+	if _, err := DigestAuthorization(`Basic realm="x"`, "GET", "/", "a", "b"); err == nil {
+		t.Error("Expected a basic challenge to be refused")
+	}
+}
@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormPart is a field of a multipart/form-data request body, with a Value or
+// the file at Path uploaded as Filename. Only the size and SHA-256 of a file
+// are recorded, its content is read by LoadBody and never written out.
+type FormPart struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Path        string `json:"path"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size_bytes"`
+	SHA256      string `json:"sha256"`
+	content     []byte
+}
+
+// ParseFormPart parses a multipart field given as name=value, or as
+// name=@path to upload the file at path.
+func ParseFormPart(field string) (FormPart, error) {
+	name, value, ok := strings.Cut(field, "=")
+	if !ok || name == "" {
+		return FormPart{}, fmt.Errorf("invalid form field '%s', expected name=value or name=@path", field)
+	}
+	path, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return FormPart{Name: name, Value: value}, nil
+	}
+	return FormPart{Name: name, Path: path, Filename: filepath.Base(path)}, nil
+}
+
+// HasBodyFiles tells whether the request body of params is read from files
+// or stdin, given as @path or @- in Data or as uploads in Multipart.
+func (params InputParams) HasBodyFiles() bool {
+	if strings.HasPrefix(params.Data, "@") {
+		return true
+	}
+	for _, part := range params.Multipart {
+		if part.Path != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadBody reads the files of the request body of params, the one of Data
+// (stdin for @-) and the uploads of Multipart, and records their size and
+// SHA-256. The content is kept in memory for RequestBody only, so that large
+// fixtures are not copied into every output that embeds the input params.
+func (params *InputParams) LoadBody(stdin io.Reader) error {
+	if path, ok := strings.CutPrefix(params.Data, "@"); ok {
+		var data []byte
+		var err error
+		switch {
+		case path == "-" && stdin == nil:
+			return fmt.Errorf("cannot read the request body from stdin here, use @path instead")
+		case path == "-":
+			data, err = io.ReadAll(stdin)
+		default:
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		params.data, params.DataSize, params.DataSHA256 = data, len(data), BodyHash(data)
+	}
+	for i := range params.Multipart {
+		part := &params.Multipart[i]
+		if part.Path == "" {
+			continue
+		}
+		content, err := os.ReadFile(part.Path)
+		if err != nil {
+			return err
+		}
+		part.content, part.Size, part.SHA256 = content, len(content), BodyHash(content)
+		if part.ContentType == "" {
+			part.ContentType = mime.TypeByExtension(filepath.Ext(part.Path))
+		}
+		if part.ContentType == "" {
+			part.ContentType = http.DetectContentType(content)
+		}
+	}
+	return nil
+}
+
+// RequestBody encodes the body of a web request from params, a multipart
+// form, a urlencoded form or the raw data, and picks its content type. Raw
+// data is sent as application/json when it is valid JSON and with the type
+// sniffed from its content otherwise.
+func (params InputParams) RequestBody() ([]byte, string, error) {
+	switch {
+	case len(params.Multipart) > 0:
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for _, part := range params.Multipart {
+			header := make(textproto.MIMEHeader)
+			disposition := map[string]string{"name": part.Name}
+			if part.Filename != "" {
+				disposition["filename"] = part.Filename
+			}
+			header.Set("Content-Disposition", mime.FormatMediaType("form-data", disposition))
+			if part.ContentType != "" {
+				header.Set("Content-Type", part.ContentType)
+			}
+			field, err := writer.CreatePart(header)
+			if err != nil {
+				return nil, "", err
+			}
+			content := []byte(part.Value)
+			if part.Path != "" {
+				if part.content == nil {
+					return nil, "", fmt.Errorf("the upload %s was not read", part.Path)
+				}
+				content = part.content
+			}
+			field.Write(content)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+		return body.Bytes(), writer.FormDataContentType(), nil
+	case len(params.Form) > 0:
+		values := make(url.Values)
+		for _, field := range params.Form {
+			name, value, ok := strings.Cut(field, "=")
+			if !ok || name == "" {
+				return nil, "", fmt.Errorf("invalid form field '%s', expected name=value", field)
+			}
+			values.Add(name, value)
+		}
+		return []byte(values.Encode()), "application/x-www-form-urlencoded", nil
+	case params.Data == "":
+		return nil, "", nil
+	}
+	data := []byte(params.Data)
+	if strings.HasPrefix(params.Data, "@") {
+		if params.data == nil {
+			return nil, "", fmt.Errorf("the request body %s was not read", params.Data)
+		}
+		data = params.data
+	}
+	if json.Valid(data) {
+		return data, "application/json", nil
+	}
+	return data, http.DetectContentType(data), nil
+}
+
+// BodyHash returns the hex encoded SHA-256 of a body.
+func BodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadBody reads a response body, at most max bytes of it when max is
+// positive, and tells whether it was cut there.
+func ReadBody(body io.Reader, max int64) ([]byte, bool, error) {
+	if max <= 0 {
+		data, err := io.ReadAll(body)
+		return data, false, err
+	}
+	data, err := io.ReadAll(io.LimitReader(body, max+1))
+	if int64(len(data)) > max {
+		return data[:max], true, err
+	}
+	return data, false, err
+}
+
+// EmbedResponseBody adds body to the response map of a WebStats the way its
+// content type calls for: JSON as a value, text as a string and anything else
+// base64 encoded, with body_encoding set. The size and SHA-256 of the body
+// are added too. Bodies sent without a content type, or as text/plain, are
+// embedded as JSON when they parse as JSON. The error tells that a body
+// declared as JSON does not parse, it is then embedded as text.
+func EmbedResponseBody(response map[string]any, body []byte, contentType string) error {
+	response["body_size_bytes"] = len(body)
+	response["body_sha256"] = BodyHash(body)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	declared := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	var err error
+	if declared || mediaType == "" || mediaType == "text/plain" {
+		var value any
+		if err = json.Unmarshal(body, &value); err == nil {
+			response["body"] = value
+			return nil
+		}
+	}
+	if !declared {
+		err = nil
+	}
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	if isText(mediaType) && utf8.Valid(body) {
+		response["body"] = string(body)
+		return err
+	}
+	response["body"] = base64.StdEncoding.EncodeToString(body)
+	response["body_encoding"] = "base64"
+	return err
+}
+
+// isText tells whether a media type is meant to be read as text.
+func isText(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// bodyExtensions are the usual extensions of the common media types, which
+// the system may know under several ones.
+var bodyExtensions = map[string]string{
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"text/xml":                 ".xml",
+	"application/xml":          ".xml",
+	"application/json":         ".json",
+	"application/octet-stream": ".bin",
+	"image/jpeg":               ".jpg",
+}
+
+// BodyPath returns the file the body of a response is saved to, empty when
+// bodies are not saved. With Output the body of every iteration after a
+// single one is numbered before the extension, with OutputDir the files are
+// named after the iteration and get the extension of their content type.
+func (params InputParams) BodyPath(iteration int, contentType string) string {
+	switch {
+	case params.Output != "" && params.Count > 1:
+		extension := filepath.Ext(params.Output)
+		return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(params.Output, extension), iteration, extension)
+	case params.Output != "":
+		return params.Output
+	case params.OutputDir != "":
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		extension, ok := bodyExtensions[mediaType]
+		if extensions, _ := mime.ExtensionsByType(mediaType); !ok && len(extensions) > 0 {
+			extension = extensions[0]
+		}
+		return filepath.Join(params.OutputDir, fmt.Sprintf("%d%s", iteration, extension))
+	}
+	return ""
+}
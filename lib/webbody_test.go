@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLoadBody reads request bodies from a file and from stdin, and keeps
+// their content out of the JSON of the input params.
+func TestLoadBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, []byte(`{"large":true}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for payload, expected := range map[string]string{
+		"inline":   "inline",
+		"@" + path: `{"large":true}`,
+		"@-":       "from stdin",
+	} {
+		params := InputParams{Data: payload}
+		if err := params.LoadBody(strings.NewReader("from stdin")); err != nil {
+			t.Fatal(err)
+		}
+		body, _, err := params.RequestBody()
+		if err != nil || string(body) != expected {
+			t.Errorf("Expected '%s' for %s, got '%s' (%v)", expected, payload, body, err)
+		}
+	}
+
+	params := InputParams{Data: "@" + path, Multipart: []FormPart{{Name: "file", Path: path}}}
+	if err := params.LoadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	hash := BodyHash([]byte(`{"large":true}`))
+	if params.DataSize != 14 || params.DataSHA256 != hash || params.Multipart[0].Size != 14 || params.Multipart[0].SHA256 != hash {
+		t.Errorf("Expected the size and hash of the fixture, got %+v", params)
+	}
+	if data, _ := json.Marshal(params); strings.Contains(string(data), "large") || strings.Contains(string(data), "eyJsYXJnZSI6") {
+		t.Errorf("Expected the content of the fixture to stay out of the JSON, got %s", data)
+	}
+	var replayed InputParams
+	json.Unmarshal([]byte(`{"data":"@`+path+`"}`), &replayed)
+	if _, _, err := replayed.RequestBody(); err == nil {
+		t.Error("Expected an error for a body that was not read")
+	}
+	if err := (&InputParams{Data: "@-"}).LoadBody(nil); err == nil {
+		t.Error("Expected an error for stdin without a reader")
+	}
+	if err := (&InputParams{Data: "@" + filepath.Join(t.TempDir(), "missing")}).LoadBody(nil); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+// TestRequestBody checks the encoding and content type picked for raw data,
+// urlencoded forms and multipart uploads.
+func TestRequestBody(t *testing.T) {
+	for _, test := range []struct {
+		params            InputParams
+		body, contentType string
+	}{
+		{InputParams{}, "", ""},
+		{InputParams{Data: `{"name": "test"}`}, `{"name": "test"}`, "application/json"},
+		{InputParams{Data: "name=test"}, "name=test", "text/plain; charset=utf-8"},
+		{InputParams{Form: []string{"name=a b", "tag=1", "tag=2"}}, "name=a+b&tag=1&tag=2", "application/x-www-form-urlencoded"},
+	} {
+		body, contentType, err := test.params.RequestBody()
+		if err != nil || string(body) != test.body || contentType != test.contentType {
+			t.Errorf("Expected '%s' as %s for %+v, got '%s' as %s (%v)", test.body, test.contentType, test.params, body, contentType, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, []byte(`{"q":3}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := ParseFormPart("file=@" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	field, _ := ParseFormPart("title=Q3")
+	params := InputParams{Multipart: []FormPart{field, file}}
+	if err := params.LoadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	body, contentType, err := params.RequestBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, options, _ := mime.ParseMediaType(contentType)
+	if mediaType != "multipart/form-data" {
+		t.Fatalf("Expected multipart/form-data, got %s", contentType)
+	}
+	form, err := multipart.NewReader(strings.NewReader(string(body)), options["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Value["title"][0] != "Q3" || len(form.File["file"]) != 1 || form.File["file"][0].Filename != "report.json" {
+		t.Fatalf("Expected the title field and report.json, got %+v", form)
+	}
+	upload, _ := form.File["file"][0].Open()
+	content, _ := io.ReadAll(upload)
+	if string(content) != `{"q":3}` || form.File["file"][0].Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the JSON content as application/json, got '%s' as %s", content, form.File["file"][0].Header.Get("Content-Type"))
+	}
+	if _, err := ParseFormPart("novalue"); err == nil {
+		t.Error("Expected an error for a field without a value")
+	}
+}
+
+// TestEmbedResponseBody checks that JSON, text and binary bodies are embedded
+// as a value, a string and base64.
+func TestEmbedResponseBody(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0xff}
+	for _, test := range []struct {
+		body        []byte
+		contentType string
+		expected    any
+		encoding    any
+		fails       bool
+	}{
+		{[]byte(`{"status":"ok"}`), "application/json", map[string]any{"status": "ok"}, nil, false},
+		{[]byte(`[1,2]`), "application/problem+json; charset=utf-8", []any{float64(1), float64(2)}, nil, false},
+		{[]byte(`{"status":"ok"}`), "", map[string]any{"status": "ok"}, nil, false},
+		{[]byte("<html></html>"), "text/html; charset=utf-8", "<html></html>", nil, false},
+		{[]byte("<html></html>"), "application/json", "<html></html>", nil, true},
+		{binary, "image/png", "iVBORw0KGgr/", "base64", false},
+		{binary, "", "iVBORw0KGgr/", "base64", false},
+	} {
+		response := make(map[string]any)
+		err := EmbedResponseBody(response, test.body, test.contentType)
+		if !reflect.DeepEqual(response["body"], test.expected) || response["body_encoding"] != test.encoding || (err != nil) != test.fails {
+			t.Errorf("Expected %#v encoded as %v for %s, got %#v as %v (%v)", test.expected, test.encoding, test.contentType, response["body"], response["body_encoding"], err)
+		}
+		if response["body_size_bytes"] != len(test.body) || response["body_sha256"] != BodyHash(test.body) {
+			t.Errorf("Expected the size and hash of the body, got %v and %v", response["body_size_bytes"], response["body_sha256"])
+		}
+	}
+}
+
+// TestReadBody checks that bodies are cut at the limit.
+func TestReadBody(t *testing.T) {
+	for _, test := range []struct {
+		max       int64
+		expected  string
+		truncated bool
+	}{
+		{0, "0123456789", false},
+		{10, "0123456789", false},
+		{4, "0123", true},
+	} {
+		body, truncated, err := ReadBody(strings.NewReader("0123456789"), test.max)
+		if err != nil || string(body) != test.expected || truncated != test.truncated {
+			t.Errorf("Expected '%s' (truncated %t) with a limit of %d, got '%s' (%t)", test.expected, test.truncated, test.max, body, truncated)
+		}
+	}
+}
+
+// TestBodyPath checks the names of the files response bodies are saved to.
+func TestBodyPath(t *testing.T) {
+	for _, test := range []struct {
+		params   InputParams
+		expected string
+	}{
+		{InputParams{Count: 1}, ""},
+		{InputParams{Count: 1, Output: "page.html"}, "page.html"},
+		{InputParams{Count: 3, Output: "out/page.html"}, "out/page.2.html"},
+		{InputParams{Count: 3, OutputDir: "bodies"}, filepath.Join("bodies", "2.json")},
+	} {
+		if path := test.params.BodyPath(2, "application/json"); path != test.expected {
+			t.Errorf("Expected '%s' for %+v, got '%s'", test.expected, test.params, path)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package lib
+
+// WebReuse compares the requests of a web run that opened their connection,
+// paying for the TCP (or QUIC) and TLS handshakes, with the ones sent over a
+// connection kept alive by an earlier request.
+type WebReuse struct {
+	Cold WebLatency `json:"cold"` // requests on a new connection
+	Warm WebLatency `json:"warm"` // requests on a reused connection
+}
+
+// WebLatency sums up the latency of successful requests.
+type WebLatency struct {
+	Requests int   `json:"requests"`
+	Min      int64 `json:"min_µs"`
+	Avg      int64 `json:"avg_µs"`
+	Max      int64 `json:"max_µs"`
+}
+
+// AnalyzeReuse splits the successful requests of stats into cold and warm
+// ones by whether they reused a connection.
+func AnalyzeReuse(stats []WebStats) WebReuse {
+	var reuse WebReuse
+	var coldTotal, warmTotal int64
+	for _, stat := range stats {
+		if !stat.Success {
+			continue
+		}
+		latency, total := &reuse.Cold, &coldTotal
+		if stat.Reused {
+			latency, total = &reuse.Warm, &warmTotal
+		}
+		if latency.Requests == 0 || stat.TimeTaken < latency.Min {
+			latency.Min = stat.TimeTaken
+		}
+		latency.Max = max(latency.Max, stat.TimeTaken)
+		latency.Requests++
+		*total += stat.TimeTaken
+	}
+	if reuse.Cold.Requests > 0 {
+		reuse.Cold.Avg = coldTotal / int64(reuse.Cold.Requests)
+	}
+	if reuse.Warm.Requests > 0 {
+		reuse.Warm.Avg = warmTotal / int64(reuse.Warm.Requests)
+	}
+	return reuse
+}
@@ -0,0 +1,23 @@
+package lib
+
+import "testing"
+
+// TestAnalyzeReuse checks that cold and warm requests are summed up apart and
+// that failed requests are left out.
+func TestAnalyzeReuse(t *testing.T) {
+	stats := []WebStats{
+		{Success: true, TimeTaken: 90000},
+		{Success: true, TimeTaken: 20000, Reused: true},
+		{Success: true, TimeTaken: 10000, Reused: true},
+		{Success: true, TimeTaken: 30000, Reused: true},
+		{Success: true, TimeTaken: 110000},
+		{TimeTaken: 5000000},
+	}
+	reuse := AnalyzeReuse(stats)
+	if reuse.Cold != (WebLatency{Requests: 2, Min: 90000, Avg: 100000, Max: 110000}) {
+		t.Errorf("Expected 2 cold requests of 90000µs to 110000µs, got %+v", reuse.Cold)
+	}
+	if reuse.Warm != (WebLatency{Requests: 3, Min: 10000, Avg: 20000, Max: 30000}) {
+		t.Errorf("Expected 3 warm requests of 10000µs to 30000µs, got %+v", reuse.Warm)
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/dmartsapp/shint/lib"
 	"github.com/dmartsapp/shint/lib/handlers"
 	"github.com/spf13/cobra"
 )
@@ -30,6 +38,60 @@ var (
 	httpdata            string
 	httpheaders         []string
 	includeresponsebody bool
+	interval            int
+	window              int
+	latencythreshold    int
+	notifyspecs         []string
+	notifylatency       int
+	notifiers           []lib.Notifier
+	notifystate         *lib.NotifyState
+	parallel            int
+	baseline            string
+	history             bool
+	historyfile         string
+	historymodule       string
+	historydays         int
+	listen              string
+	agenttoken          string
+	maxconcurrent       int
+	vantages            []string
+	successthreshold    string
+	threshold           float64
+	configfile          string
+	profile             string
+	config              lib.Config
+	configured          map[string]string // flag name to the lib.Config* source applyConfig set it from
+	interactive         bool
+	crlf                bool
+	hexdump             bool
+	negotiate           bool
+	send                string
+	sendfile            string
+	expect              string
+	expectregex         string
+	udp                 bool
+	tcpport             int
+	queries             int
+	maxhops             int
+	fallbackport        int
+	maxmtu              int
+	http11              bool
+	http2               bool
+	h2c                 bool
+	http3               bool
+	reuse               bool
+	httpform            []string
+	httpmultipart       []string
+	bodyoutput          string
+	bodyoutputdir       string
+	maxbody             int64
+	webuser             string
+	digest              bool
+	bearerfile          string
+	oauth2tokenurl      string
+	oauth2clientid      string
+	oauth2clientsecret  string
+	oauth2scopes        []string
 )
 
 var rootCmd = &cobra.Command{
@@ -37,35 +99,290 @@ var rootCmd = &cobra.Command{
 	Short:   "SHINT - that SHIt Network Tool",
 	Long:    `A simple network utility tool that provides telnet, ping, nmap, and web client functionalities.`,
 	Version: Version,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+		var err error
+		if threshold, err = lib.ParseSuccessThreshold(successthreshold); err != nil {
+			return err
+		}
+		for _, spec := range notifyspecs {
+			notifier, err := lib.ParseNotifier(spec)
+			if err != nil {
+				return err
+			}
+			notifiers = append(notifiers, notifier)
+		}
+		if len(notifiers) > 0 {
+			state, err := lib.LoadNotifyState(lib.DefaultNotifyStatePath())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, lib.LogWithTimestamp("Unable to read notification state: "+err.Error(), true))
+			}
+			notifystate = state
+		}
+		return nil
+	},
+}
+
+// applyConfig fills the flags of cmd that were not given on the command line
+// from the environment, the --profile and the configuration file.
+func applyConfig(cmd *cobra.Command) error {
+	var err error
+	configured, err = config.ApplyFlags(cmd.Flags(), strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "), profile)
+	return err
+}
+
+// given tells whether a flag was set on the command line or by applyConfig,
+// from any source.
+func given(name string) bool {
+	flag := rootCmd.PersistentFlags().Lookup(name)
+	return (flag != nil && flag.Changed) || configured[name] != ""
+}
+
+// givenFor tells whether a flag was set for the command being run, on the
+// command line, by the --profile or in the section of the command, rather
+// than by the environment or the defaults shared by every command.
+func givenFor(name string) bool {
+	if flag := rootCmd.PersistentFlags().Lookup(name); flag != nil && flag.Changed {
+		return true
+	}
+	return configured[name] == lib.ConfigProfile || configured[name] == lib.ConfigCommand
+}
+
+// recordsHistory tells whether results go to the history store, with
+// --history or once a history file is given, by flag or configuration.
+func recordsHistory() bool {
+	return history || given("history-file")
+}
+
+// expandProfile loads the configuration file and, when a --profile is given,
+// adds the command and positional arguments it bundles to the command line,
+// the way an alias would.
+func expandProfile(args []string) ([]string, error) {
+	path, name := os.Getenv("SHINT_CONFIG"), os.Getenv("SHINT_PROFILE")
+	for i := 0; i < len(args) && args[i] != "--"; i++ {
+		for _, flag := range []string{"--config", "--profile"} {
+			value, found := "", false
+			if strings.HasPrefix(args[i], flag+"=") {
+				value, found = strings.TrimPrefix(args[i], flag+"="), true
+			} else if args[i] == flag && i+1 < len(args) {
+				value, found = args[i+1], true
+			}
+			if found && flag == "--config" {
+				path = value
+			} else if found {
+				name = value
+			}
+		}
+	}
+	profile = name
+	var err error
+	if config, err = lib.LoadConfig(cmp.Or(path, configfile), path != ""); err != nil || name == "" {
+		return args, err
+	}
+	selected, err := config.Profile(name)
+	if err != nil {
+		return args, err
+	}
+	found, _, err := rootCmd.Find(args)
+	if err != nil || selected.Command == "" {
+		return append(args, selected.Args...), nil
+	}
+	if found == rootCmd {
+		args = append(strings.Fields(selected.Command), args...)
+	} else if found.CommandPath() != rootCmd.Name()+" "+selected.Command {
+		return args, nil // the mismatch is reported once the flags are parsed
+	}
+	return append(args, selected.Args...), nil
+}
+
+// fanOut sends the probe of a command to the --from agents instead of running
+// it locally and prints the per vantage comparison.
+func fanOut(ctx context.Context, module string, args []string) {
+	params, err := inputParams(module, args)
+	if err == nil {
+		err = params.Normalize()
+	}
+	if err == nil && (params.Output != "" || params.OutputDir != "") {
+		err = fmt.Errorf("response bodies cannot be saved from probes run on agents, --output and --output-dir only work locally")
+	}
+	if err == nil && params.HasBodyFiles() {
+		err = fmt.Errorf("request bodies read from files or stdin cannot be sent to agents, give -P and -F the data inline instead")
+	}
+	if err != nil {
+		fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+		os.Exit(lib.ExitUsage)
+	}
+	if agenttoken == "" {
+		agenttoken = os.Getenv("SHINT_AGENT_TOKEN")
+	}
+	codes := make([]int, 0)
+	for _, result := range handlers.FanOutHandler(ctx, params, vantages, agenttoken, &jsonoutput) {
+		if result.Error != "" {
+			codes = append(codes, lib.ExitFailure)
+		} else {
+			codes = append(codes, lib.ExitCode(result.Result, threshold))
+		}
+	}
+	os.Exit(lib.CombineExitCodes(codes...))
+}
+
+// exit ends the process with the exit code matching the outputs of a command,
+// taking --success-threshold and the expectations of the --profile into account.
+func exit(outputs ...lib.JSONOutput) {
+	var expect *lib.PlanExpect
+	if profile != "" {
+		selected, _ := config.Profile(profile)
+		expect = selected.Expect
+	}
+	codes := make([]int, 0, len(outputs))
+	for _, output := range outputs {
+		code := lib.ExitCode(output, threshold)
+		if expect != nil {
+			failures := lib.PlanCheck{Assert: *expect}.Verify(output)
+			for _, failure := range failures {
+				if !jsonoutput {
+					fmt.Println(lib.LogWithTimestamp("Expectation of profile '"+profile+"' not met: "+failure, true))
+				}
+			}
+			if len(failures) > 0 && code == lib.ExitSuccess {
+				code = lib.ExitPartial
+			}
+		}
+		codes = append(codes, code)
+	}
+	os.Exit(lib.CombineExitCodes(codes...))
+}
+
+// report hands the result of a command to the --notify sinks and the
+// --history store, if enabled.
+func report(output lib.JSONOutput) {
+	if recordsHistory() {
+		if err := lib.AppendHistory(historyfile, output); err != nil {
+			fmt.Fprintln(os.Stderr, lib.LogWithTimestamp("Unable to record history: "+err.Error(), true))
+		}
+	}
+	if len(notifiers) == 0 {
+		return
+	}
+	if _, err := lib.Dispatch(context.Background(), notifiers, notifystate, output, time.Duration(notifylatency)*time.Millisecond); err != nil {
+		fmt.Fprintln(os.Stderr, lib.LogWithTimestamp("Notification failed: "+err.Error(), true))
+	}
 }
 
 var telnetCmd = &cobra.Command{
 	Use:   "telnet [host] [port]",
 	Short: "Connect to a host on a specific port",
-	Long:  `This command allows you to test connectivity to a host on a specific port using TCP.`,
-	Args:  cobra.ExactArgs(2),
+	Long: `This command allows you to test connectivity to a host on a specific port using TCP. With --interactive it
+keeps the connection open and pipes stdin and stdout through it like netcat, answering the option negotiation
+of real telnet servers (automatically on port 23, or with --negotiate).`,
+	Example: rootCmd.Name() + " telnet --interactive --crlf mail.example.com 25",
+	Args:    cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
+		if len(vantages) > 0 {
+			fanOut(cmd.Context(), "telnet", args)
+			return
+		}
 		host := args[0]
 		port, err := strconv.Atoi(args[1])
 		if err != nil {
 			fmt.Println("Invalid port number")
+			os.Exit(lib.ExitUsage)
+		}
+		if interactive {
+			err := handlers.TelnetInteractiveHandler(cmd.Context(), host, port, timeout, udp, crlf, hexdump, negotiate || port == 23)
+			var dnserr *net.DNSError
+			switch {
+			case errors.As(err, &dnserr):
+				os.Exit(lib.ExitDNS)
+			case err != nil:
+				os.Exit(lib.ExitFailure)
+			}
 			return
 		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-		defer cancel()
-
-		handlers.TelnetHandler(&jsonoutput, iterations, delay, &throttle, timeout, payload_size, port, ctx, host)
+		params, err := inputParams("telnet", args)
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		output := handlers.TelnetProbeHandler(cmd.Context(), params, &jsonoutput)
+		report(output)
+		exit(output)
 	},
 }
 
 var pingCmd = &cobra.Command{
 	Use:   "ping [host]",
 	Short: "Send ICMP ECHO_REQUEST to a host",
-	Long:  `This command sends ICMP ECHO_REQUEST packets to a host to test reachability.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `This command sends ICMP ECHO_REQUEST packets to a host to test reachability. With --tcp it measures
+reachability with repeated TCP handshakes to a port instead, and it falls back to that on --fallback-port when
+ICMP is not permitted for the process or the host does not answer it.`,
+	Example: rootCmd.Name() + " ping --tcp 443 --count 5 example.com",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(vantages) > 0 {
+			fanOut(cmd.Context(), "ping", args)
+			return
+		}
+		params, err := inputParams("ping", args)
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		output := handlers.PingHandler(cmd.Context(), params, &jsonoutput)
+		report(output)
+		exit(output)
+	},
+}
+
+var traceCmd = &cobra.Command{
+	Use:   "trace [host]",
+	Short: "Discover the network path to a host hop by hop",
+	Long: `This command discovers the routers on the path to a host by sending probes with increasing TTLs, with ICMP
+echo requests or with TCP SYNs to a port (--tcp) to get through firewalls that drop ICMP. Like mtr, every hop
+is probed --queries times and reported with its address, reverse DNS name, loss and latency. Listening to the
+routers needs a raw socket, i.e. root or the CAP_NET_RAW capability.`,
+	Example: rootCmd.Name() + " trace --tcp 443 --queries 5 example.com",
+	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		handlers.HandleICMP(args[0], &jsonoutput, iterations, delay, &throttle, timeout, payload_size)
+		if len(vantages) > 0 {
+			fanOut(cmd.Context(), "trace", args)
+			return
+		}
+		params, err := inputParams("trace", args)
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		output := handlers.TraceHandler(cmd.Context(), params, &jsonoutput)
+		report(output)
+		exit(output)
+	},
+}
+
+var mtuCmd = &cobra.Command{
+	Use:   "mtu [host]",
+	Short: "Discover the path MTU to a host",
+	Long: `This command binary-searches the largest packet that reaches a host without fragmentation, sending ICMP
+echo requests with the don't fragment bit set, and reports the path MTU and the router that refused larger
+packets, or that they were silently dropped. Sizes that get no answer are retried --count times (2 by default).
+Setting the don't fragment bit needs a raw socket, i.e. root or the CAP_NET_RAW capability.`,
+	Example: rootCmd.Name() + " mtu --timeout 1 vpn.example.com",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(vantages) > 0 {
+			fanOut(cmd.Context(), "mtu", args)
+			return
+		}
+		params, err := inputParams("mtu", args)
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		output := handlers.MTUHandler(cmd.Context(), params, &jsonoutput)
+		report(output)
+		exit(output)
 	},
 }
 
@@ -76,32 +393,350 @@ var webCmd = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	Example: rootCmd.Name() + " web --json -H \"authorization:Bearer <token>\" -H \"content-type:application/json\" http://google.com --count 1",
 	Run: func(cmd *cobra.Command, args []string) {
-		URL, err := url.Parse(args[0])
-		if URL.Scheme == "" {
-			URL.Scheme = "https"
+		if len(vantages) > 0 {
+			fanOut(cmd.Context(), "web", args)
+			return
 		}
+		params, err := inputParams("web", args)
 		if err != nil {
-			fmt.Println("Invalid URL")
-			return
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
 		}
-
-		handlers.WebHandler(&jsonoutput, iterations, delay, &throttle, timeout, URL, httpmethod, httpdata, httpheaders, includeresponsebody)
+		output := handlers.WebProbeHandler(cmd.Context(), params, &jsonoutput)
+		report(output)
+		exit(output)
 	},
 }
 
 var nmapCmd = &cobra.Command{
 	Use:   "nmap [host]",
-	Short: "Scan for open TCP ports on a host",
-	Long:  `This command scans for open TCP ports on a host within a given range.`,
+	Short: "Scan for open TCP or UDP ports on a host",
+	Long:  `This command scans for open TCP ports (or UDP ports with --udp) on a host within a given range.`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-		defer cancel()
+		if len(vantages) > 0 {
+			fanOut(cmd.Context(), "nmap", args)
+			return
+		}
+		ctx := cmd.Context()
+		if baseline == "" {
+			params, _ := inputParams("nmap", args)
+			output := handlers.NmapProbeHandler(ctx, params, &jsonoutput)
+			report(output)
+			exit(output)
+		}
+		previous, err := loadNmapOutput(baseline)
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		params, _ := inputParams("nmap", args)
+		output, diff := handlers.NmapBaselineHandler(ctx, params, previous, &jsonoutput)
+		report(output)
+		if diff.ExposureGrew {
+			os.Exit(lib.ExitExposed)
+		}
+		exit(output)
+	},
+}
+
+var nmapDiffCmd = &cobra.Command{
+	Use:   "diff [old.json] [new.json]",
+	Short: "Compare two nmap JSON results for port exposure changes",
+	Long: `This command compares two nmap results produced with --json and reports newly opened ports, newly closed
+ports and hosts that appeared or disappeared. It exits with 5 when the exposure grew.`,
+	Example: rootCmd.Name() + " nmap diff last-week.json today.json",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		previous, err := loadNmapOutput(args[0])
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		current, err := loadNmapOutput(args[1])
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		if handlers.NmapDiffHandler(previous, current, &jsonoutput).ExposureGrew {
+			os.Exit(lib.ExitExposed)
+		}
+	},
+}
+
+// loadNmapOutput reads an nmap result printed with --json, either on its own
+// or wrapped together with a diff by --baseline.
+func loadNmapOutput(path string) (lib.JSONOutput, error) {
+	var output lib.JSONOutput
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return output, err
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		return output, err
+	}
+	if output.ModuleName != "nmap" {
+		var wrapped struct {
+			Result lib.JSONOutput `json:"result"`
+		}
+		if json.Unmarshal(data, &wrapped) == nil && wrapped.Result.ModuleName == "nmap" {
+			return wrapped.Result, nil
+		}
+		return output, fmt.Errorf("%s is not an nmap result", path)
+	}
+	return output, nil
+}
+
+var monitorCmd = &cobra.Command{
+	Use:   "monitor [telnet|ping|web|nmap] [target] [port]",
+	Short: "Continuously probe a target and report state changes",
+	Long: `This command runs any probe indefinitely at the given interval and only reports when the state changes:
+the target going down or coming back up (with the outage duration), the rolling average latency crossing
+--latency-threshold, or the DNS answer for the target changing.`,
+	Example: rootCmd.Name() + " monitor telnet google.com 443 --interval 5 --latency-threshold 200",
+	Args:    cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		params, err := inputParams(args[0], args[1:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(lib.ExitUsage)
+		}
+		if interval < 1 {
+			fmt.Println("Interval must be at least 1 second")
+			os.Exit(lib.ExitUsage)
+		}
+		handlers.MonitorHandler(cmd.Context(), params, interval, window, latencythreshold, &jsonoutput, report)
+	},
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run [plan]",
+	Short: "Execute the checks listed in a YAML or JSON test plan",
+	Long: `This command executes the named checks of a YAML or JSON plan concurrently and prints a combined report.
+Every check takes the same fields as the "input_params" block of the JSON output, plus a name and the expectations under "assert".
+The command exits with 1 when some checks fail or the run is interrupted, and with 2 when all of them fail.`,
+	Example: rootCmd.Name() + " run plan.yaml --parallel 8",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		plan, err := lib.LoadPlan(args[0])
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		if plan.Concurrency > 0 && !cmd.Flags().Changed("parallel") {
+			parallel = plan.Concurrency
+		}
+		os.Exit(handlers.RunPlanHandler(cmd.Context(), plan, parallel, &jsonoutput, report).ExitCode())
+	},
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay [result.json]",
+	Short: "Run a previous probe again from its JSON output",
+	Long: `This command reads the JSON output of an earlier run, reconstructs the probe from its "input_params"
+(module, host, ports, method, headers, data, count, delay) and runs it again, then prints a side by side
+comparison of success and latency against the original.`,
+	Example: rootCmd.Name() + " replay result.json",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		original, err := lib.LoadJSONOutput(args[0])
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitUsage)
+		}
+		output := handlers.ReplayHandler(cmd.Context(), original, &jsonoutput)
+		report(output)
+		exit(output)
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [target]",
+	Short: "Show latency and success trends recorded with --history",
+	Long: `This command reads the results recorded by running commands with --history and shows the daily
+success rate and average latency for a target, with sparklines. The target is matched against the host,
+host:port (telnet) or URL (web) that was probed.`,
+	Example: rootCmd.Name() + " history google.com:443 --days 14",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		handlers.HistoryHandler(historyfile, args[0], historymodule, historydays, &jsonoutput)
+	},
+}
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the --json output",
+	Long: `This command prints the JSON Schema (draft 2020-12) of the output written with --json, generated from
+the types that produce it. Every output carries the schema_version it was written in, so parsers can tell
+when the format changed. The schema describes the output of the probe commands; the other documents, the
+reports of run, replay, history, nmap diff and --baseline, the comparison of --from and the notifications,
+embed that output and carry the same schema_version.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		JS, err := json.MarshalIndent(lib.JSONSchema(), "", "  ")
+		if err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitFailure)
+		}
+		fmt.Println(string(JS))
+	},
+}
 
-		handlers.NmapHandler(ctx, args[0], fromport, endport, iterations, timeout, throttle, &jsonoutput)
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Serve the probes over an HTTP API for remote coordinators",
+	Long: `This command runs shint as a daemon exposing POST /v1/probes/telnet, /v1/probes/ping, /v1/probes/web,
+/v1/probes/nmap, /v1/probes/trace and /v1/probes/mtu. Each accepts a JSON body shaped like the "input_params" of the JSON output and answers
+with the JSON output of the probe. The bearer token can also be given in SHINT_AGENT_TOKEN.`,
+	Example: rootCmd.Name() + " agent --listen :8080 --token s3cr3t --max-concurrent 8",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if agenttoken == "" {
+			agenttoken = os.Getenv("SHINT_AGENT_TOKEN")
+		}
+		if err := handlers.AgentHandler(cmd.Context(), listen, agenttoken, maxconcurrent, &jsonoutput); err != nil {
+			fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+			os.Exit(lib.ExitFailure)
+		}
 	},
 }
 
+// unescape interprets the Go escape sequences of a --send value, such as \r\n,
+// and leaves values that are not valid escaped strings as they are.
+func unescape(value string) string {
+	unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(value, `"`, `\"`) + `"`)
+	if err != nil {
+		return value
+	}
+	return unquoted
+}
+
+// inputParams builds the lib.InputParams for a module from its positional
+// arguments and the global flags, the same way the module's own command does.
+func inputParams(module string, args []string) (lib.InputParams, error) {
+	params := lib.InputParams{
+		Mode:     module,
+		Protocol: "tcp",
+		Timeout:  timeout,
+		Count:    iterations,
+		Delay:    delay,
+		Payload:  payload_size,
+		Throttle: throttle,
+	}
+	switch module {
+	case "telnet":
+		if len(args) != 2 {
+			return params, fmt.Errorf("telnet requires a host and a port")
+		}
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return params, fmt.Errorf("invalid port number")
+		}
+		params.Host, params.FromPort, params.ToPort = args[0], port, port
+		params.Send, params.Expect = unescape(send), expect
+		if sendfile != "" {
+			data, err := os.ReadFile(sendfile)
+			if err != nil {
+				return params, err
+			}
+			params.Send = string(data)
+		}
+		if expectregex != "" {
+			params.Expect, params.ExpectRegex = expectregex, true
+		}
+		params.RandomPayload = params.Send == "" && givenFor("payload")
+		if udp {
+			params.Protocol = "udp"
+		}
+		if _, err := params.ExpectPattern(); err != nil {
+			return params, err
+		}
+	case "ping", "icmp":
+		params.Mode, params.Protocol = "icmp", "icmp"
+		params.Host, params.FromPort, params.ToPort = args[0], 7, 7
+		if tcpport > 0 {
+			params.Protocol, params.FromPort, params.ToPort = "tcp", tcpport, tcpport
+		}
+		params.FallbackPort = fallbackport
+	case "web":
+		URL, err := url.Parse(args[0])
+		if err != nil {
+			return params, fmt.Errorf("invalid URL")
+		}
+		if URL.Scheme == "" {
+			URL, _ = url.Parse("https://" + args[0])
+		}
+		params.Host, params.URL = URL.Host, URL.String()
+		params.Method, params.Data, params.Headers, params.WithBody = httpmethod, httpdata, httpheaders, includeresponsebody
+		params.Form = httpform
+		for _, field := range httpmultipart {
+			part, err := lib.ParseFormPart(field)
+			if err != nil {
+				return params, err
+			}
+			params.Multipart = append(params.Multipart, part)
+		}
+		if len(vantages) == 0 { // fanOut refuses request bodies read from files
+			if err := params.LoadBody(os.Stdin); err != nil {
+				return params, err
+			}
+		}
+		params.Payload = len(params.Data) + len(httpheaders)
+		if params.DataSHA256 != "" {
+			params.Payload = params.DataSize + len(httpheaders)
+		}
+		params.Output, params.OutputDir, params.MaxBody = bodyoutput, bodyoutputdir, maxbody
+		params.User, params.Digest = webuser, digest
+		if bearerfile != "" {
+			token, err := os.ReadFile(bearerfile)
+			if err != nil {
+				return params, err
+			}
+			params.Bearer = strings.TrimSpace(string(token))
+		}
+		if oauth2clientsecret == "" {
+			oauth2clientsecret = os.Getenv("SHINT_OAUTH2_CLIENT_SECRET")
+		}
+		params.OAuth2TokenURL, params.OAuth2ClientID, params.OAuth2ClientSecret, params.OAuth2Scopes = oauth2tokenurl, oauth2clientid, oauth2clientsecret, oauth2scopes
+		params.Reuse = reuse
+		for version, set := range map[string]bool{lib.HTTP11: http11, lib.HTTP2: http2, lib.H2C: h2c, lib.HTTP3: http3} {
+			if set {
+				params.HTTPVersion = version
+			}
+		}
+		if err := params.Normalize(); err != nil {
+			return params, err
+		}
+	case "nmap":
+		params.Host, params.FromPort, params.ToPort = args[0], fromport, endport
+		params.Delay, params.Payload = 0, 0
+		if udp {
+			params.Protocol = "udp"
+		}
+	case "trace":
+		if queries < 1 || maxhops < 1 || maxhops > 255 {
+			return params, fmt.Errorf("trace requires at least 1 query and between 1 and 255 hops")
+		}
+		params.Host, params.FromPort, params.ToPort = args[0], tcpport, tcpport
+		params.Count, params.MaxHops, params.Protocol = queries, maxhops, "icmp"
+		if tcpport > 0 {
+			params.Protocol = "tcp"
+		}
+	case "mtu":
+		if maxmtu < 68 || maxmtu > 65535 {
+			return params, fmt.Errorf("--max-mtu must be between 68 and 65535 bytes")
+		}
+		params.Host, params.Protocol, params.MaxMTU = args[0], "icmp", maxmtu
+		params.Delay, params.Payload = 0, 0
+		if !givenFor("count") {
+			params.Count = 2
+		}
+	default:
+		return params, fmt.Errorf("unknown module '%s', expected one of telnet, ping, web, nmap, trace or mtu", module)
+	}
+	return params, nil
+}
+
 func init() {
 	rootCmd.PersistentFlags().IntVar(&iterations, "count", 1, "Number of times to check connectivity")
 	rootCmd.PersistentFlags().IntVar(&timeout, "timeout", 5, "Timeout in seconds to connect")
@@ -109,20 +744,93 @@ func init() {
 	rootCmd.PersistentFlags().IntVar(&payload_size, "payload", 4, "Ping payload size in bytes")
 	rootCmd.PersistentFlags().BoolVar(&throttle, "throttle", false, "Flag option to throttle between every iteration of count to simulate non-uniform request.")
 	rootCmd.PersistentFlags().BoolVar(&jsonoutput, "json", false, "Flag option to output only in JSON format")
+	rootCmd.PersistentFlags().StringArrayVar(&notifyspecs, "notify", []string{}, "Notify on failure and recovery: webhook:URL, slack:URL, smtp://host:port?from=..&to=.. or exec:command (can be specified multiple times)")
+	rootCmd.PersistentFlags().BoolVar(&history, "history", false, "Record every result in the local history store for the history command")
+	rootCmd.PersistentFlags().StringVar(&historyfile, "history-file", lib.DefaultHistoryPath(), "History store location, setting it implies --history")
+	rootCmd.PersistentFlags().StringSliceVar(&vantages, "from", []string{}, "Run the probe on these agents (name, host:port or URL) instead of locally and compare the results")
+	// nmap and monitor keep --from as their start port, --agents works everywhere
+	rootCmd.PersistentFlags().StringSliceVar(&vantages, "agents", []string{}, "Alias of --from, for nmap and monitor where --from is the start port")
+	rootCmd.PersistentFlags().StringVar(&agenttoken, "agent-token", "", "Bearer token sent to the --from agents (defaults to $SHINT_AGENT_TOKEN)")
+	rootCmd.PersistentFlags().StringVar(&configfile, "config", lib.DefaultConfigPath(), "Configuration file with flag defaults per command and named profiles (also $SHINT_CONFIG)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Named profile of the configuration file bundling the command, target, flags and expectations")
+	rootCmd.PersistentFlags().StringVar(&successthreshold, "success-threshold", "100%", "Exit with 0 when at least this percentage of the probes succeeded, e.g. 80% for lossy checks")
+	rootCmd.PersistentFlags().IntVar(&notifylatency, "notify-latency", 0, "Also notify when the average latency in milliseconds exceeds this value (0 disables)")
+	telnetCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Keep the connection open and pipe stdin and stdout through it, like netcat")
+	telnetCmd.Flags().BoolVar(&crlf, "crlf", false, "Send line feeds read from stdin as CR LF in --interactive mode")
+	telnetCmd.Flags().BoolVar(&hexdump, "hexdump", false, "Show the bytes exchanged in --interactive mode as a hex dump")
+	telnetCmd.Flags().StringVar(&send, "send", "", "Data to send after connecting, escapes such as \\r\\n are interpreted (--payload sends that many random bytes instead)")
+	telnetCmd.Flags().StringVar(&sendfile, "send-file", "", "File whose content is sent after connecting")
+	telnetCmd.Flags().StringVar(&expect, "expect", "", "Text the response must contain for the check to succeed")
+	telnetCmd.Flags().StringVar(&expectregex, "expect-regex", "", "Regular expression the response must match for the check to succeed")
+	telnetCmd.Flags().BoolVar(&udp, "udp", false, "Probe over UDP, sending --send or a request for the service of well known ports (DNS, NTP, SNMP)")
+	telnetCmd.Flags().BoolVar(&negotiate, "negotiate", false, "Answer Telnet option negotiation in --interactive mode (always on for port 23)")
 	webCmd.Flags().StringVarP(&httpmethod, "method", "X", "GET", "HTTP method to use (GET, POST, PUT, DELETE)")
-	webCmd.Flags().StringVarP(&httpdata, "payload", "P", "", "HTTP payload data to send")
+	webCmd.Flags().StringVarP(&httpdata, "payload", "P", "", "HTTP payload data to send, @file to send the content of a file or @- to read it from stdin")
+	webCmd.Flags().StringArrayVar(&httpform, "form", []string{}, "Form field name=value to send urlencoded (can be specified multiple times)")
+	webCmd.Flags().StringArrayVarP(&httpmultipart, "multipart", "F", []string{}, "Multipart form field name=value, or name=@path to upload a file (can be specified multiple times)")
 	webCmd.Flags().StringArrayVarP(&httpheaders, "header", "H", []string{}, "HTTP headers to send (can be specified multiple times)")
 	webCmd.Flags().BoolVarP(&includeresponsebody, "withbody", "W", false, "Include the response body in the JSON output")
+	webCmd.MarkFlagsMutuallyExclusive("payload", "form", "multipart")
+	webCmd.Flags().StringVarP(&bodyoutput, "output", "o", "", "Save the response body to this file, numbered before the extension when --count is above 1")
+	webCmd.Flags().StringVar(&bodyoutputdir, "output-dir", "", "Save the response body of every iteration to this directory")
+	webCmd.MarkFlagsMutuallyExclusive("output", "output-dir")
+	webCmd.Flags().Int64Var(&maxbody, "max-body", 0, "Read at most this many bytes of each response body, 0 for no limit")
+	webCmd.Flags().StringVarP(&webuser, "user", "u", "", "Authenticate with user:password, in HTTP basic authentication unless --digest is given")
+	webCmd.Flags().BoolVar(&digest, "digest", false, "Answer the digest authentication challenge of the server with --user")
+	webCmd.Flags().StringVar(&bearerfile, "bearer-file", "", "File holding a bearer token to send in the authorization header")
+	webCmd.Flags().StringVar(&oauth2tokenurl, "oauth2-token-url", "", "OAuth2 token endpoint to get a bearer token from with the client credentials grant before the requests")
+	webCmd.Flags().StringVar(&oauth2clientid, "oauth2-client-id", "", "OAuth2 client id for --oauth2-token-url")
+	webCmd.Flags().StringVar(&oauth2clientsecret, "oauth2-client-secret", "", "OAuth2 client secret for --oauth2-token-url (defaults to $SHINT_OAUTH2_CLIENT_SECRET)")
+	webCmd.Flags().StringSliceVar(&oauth2scopes, "oauth2-scope", []string{}, "OAuth2 scope to request (can be specified multiple times or comma separated)")
+	webCmd.MarkFlagsMutuallyExclusive("user", "bearer-file", "oauth2-token-url")
+	webCmd.Flags().BoolVar(&http11, "http1.1", false, "Only use HTTP/1.1")
+	webCmd.Flags().BoolVar(&http2, "http2", false, "Only use HTTP/2 negotiated over TLS")
+	webCmd.Flags().BoolVar(&h2c, "h2c", false, "Only use HTTP/2 without TLS (prior knowledge) on an http:// URL")
+	webCmd.Flags().BoolVar(&http3, "http3", false, "Only use HTTP/3 over QUIC")
+	webCmd.MarkFlagsMutuallyExclusive("http1.1", "http2", "h2c", "http3")
+	webCmd.Flags().BoolVar(&reuse, "reuse", false, "Send the requests one after another over kept alive connections and report cold and warm latency apart")
 	nmapCmd.Flags().IntVar(&fromport, "from", 1, "Start port for TCP scan")
 	nmapCmd.Flags().IntVar(&endport, "to", 80, "End port for TCP scan")
+	nmapCmd.Flags().BoolVar(&udp, "udp", false, "Scan UDP ports, classifying them as open, open|filtered or closed")
+	nmapCmd.Flags().StringVar(&baseline, "baseline", "", "Previous nmap JSON result to compare this scan against")
+	nmapCmd.AddCommand(nmapDiffCmd)
+	pingCmd.Flags().IntVar(&tcpport, "tcp", 0, "Ping with TCP handshakes to this port instead of ICMP echo requests")
+	pingCmd.Flags().IntVar(&fallbackport, "fallback-port", 443, "Switch to TCP handshakes with this port when ICMP is not available or not answered (0 disables)")
+	mtuCmd.Flags().IntVar(&maxmtu, "max-mtu", 1500, "Largest packet size tried, e.g. 9000 for jumbo frames")
+	traceCmd.Flags().IntVar(&tcpport, "tcp", 0, "Trace with TCP SYNs to this port instead of ICMP echo requests")
+	traceCmd.Flags().IntVarP(&queries, "queries", "q", 3, "Number of probes sent to every hop")
+	traceCmd.Flags().IntVar(&maxhops, "max-hops", 30, "Highest TTL probed before giving up on reaching the host")
+	runCmd.Flags().IntVar(&parallel, "parallel", 4, "Maximum number of checks executed at the same time")
+	agentCmd.Flags().StringVar(&listen, "listen", ":8080", "Address the agent API listens on")
+	agentCmd.Flags().StringVar(&agenttoken, "token", "", "Bearer token required from API clients (defaults to $SHINT_AGENT_TOKEN)")
+	agentCmd.Flags().IntVar(&maxconcurrent, "max-concurrent", 4, "Maximum number of probes the agent runs at the same time")
+	historyCmd.Flags().StringVar(&historymodule, "module", "", "Only show results of this module (telnet, ping, trace, mtu, web, nmap)")
+	historyCmd.Flags().IntVar(&historydays, "days", 7, "Number of days to look back")
+	monitorCmd.Flags().IntVar(&interval, "interval", 10, "Seconds between each probe round")
+	monitorCmd.Flags().IntVar(&window, "window", 10, "Number of recent latency samples averaged for the latency threshold")
+	monitorCmd.Flags().IntVar(&latencythreshold, "latency-threshold", 0, "Average latency in milliseconds above which the target is reported slow (0 disables)")
+	monitorCmd.Flags().StringVarP(&httpmethod, "method", "X", "GET", "HTTP method to use for web probes")
+	monitorCmd.Flags().StringArrayVarP(&httpheaders, "header", "H", []string{}, "HTTP headers to send for web probes (can be specified multiple times)")
+	monitorCmd.Flags().IntVar(&fromport, "from", 1, "Start port for nmap probes")
+	monitorCmd.Flags().IntVar(&endport, "to", 80, "End port for nmap probes")
 	rootCmd.SetVersionTemplate(`{{printf "%s\n" .Version}}`)
 	rootCmd.Version = Version
 }
 
 func main() {
-	rootCmd.AddCommand(telnetCmd, pingCmd, webCmd, nmapCmd)
-	if err := rootCmd.Execute(); err != nil {
+	rootCmd.AddCommand(telnetCmd, pingCmd, traceCmd, mtuCmd, webCmd, nmapCmd, monitorCmd, runCmd, replayCmd, historyCmd, agentCmd, schemaCmd)
+	// Ctrl-C and SIGTERM cancel the context handed to every command, which stops
+	// scheduling new probes and still prints the statistics gathered so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	args, err := expandProfile(os.Args[1:])
+	if err != nil {
+		fmt.Println(lib.LogWithTimestamp(err.Error(), true))
+		os.Exit(lib.ExitUsage)
+	}
+	rootCmd.SetArgs(args)
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(lib.ExitUsage)
 	}
 }
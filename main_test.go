@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dmartsapp/shint/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// TestRecordsHistory turns the history on from a history file given in the
+// environment or the configuration file, not only on the command line.
+func TestRecordsHistory(t *testing.T) {
+	if err := rootCmd.ParseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	config = lib.Config{}
+	if err := applyConfig(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	if recordsHistory() {
+		t.Error("Expected no history without --history or a history file")
+	}
+
+	t.Setenv("SHINT_HISTORY_FILE", path)
+	if err := applyConfig(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	if !recordsHistory() || historyfile != path {
+		t.Errorf("Expected the history in %s from the environment, got %v in %s", path, recordsHistory(), historyfile)
+	}
+
+	os.Unsetenv("SHINT_HISTORY_FILE")
+	config = lib.Config{Defaults: map[string]any{"history-file": path + ".defaults"}}
+	if err := applyConfig(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	if !recordsHistory() || historyfile != path+".defaults" {
+		t.Errorf("Expected the history in %s.defaults from the configuration file, got %v in %s", path, recordsHistory(), historyfile)
+	}
+}
+
+// parseCommandLine parses args like the command of the module would, from
+// the defaults of its flags, into the params it runs.
+func parseCommandLine(t *testing.T, args []string) lib.InputParams {
+	t.Helper()
+	commands := map[string]*cobra.Command{"telnet": telnetCmd, "ping": pingCmd, "web": webCmd, "nmap": nmapCmd, "trace": traceCmd, "mtu": mtuCmd}
+	flags := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	flags.AddFlagSet(commands[args[0]].Flags()) // the local flags win, like --from of nmap
+	flags.AddFlagSet(rootCmd.PersistentFlags())
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if value, ok := flag.Value.(pflag.SliceValue); ok {
+			value.Replace(nil)
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	})
+	configured = nil
+	if err := flags.Parse(args[1:]); err != nil {
+		t.Fatal(err)
+	}
+	params, err := inputParams(args[0], flags.Args())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+// splitCommandLine splits a line rendered by lib.CommandLine into its
+// arguments, unquoting the quoted ones.
+func splitCommandLine(t *testing.T, line string) []string {
+	t.Helper()
+	var args []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if strings.HasPrefix(line, `"`) {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				t.Fatalf("Invalid quoted argument in %s: %v", line, err)
+			}
+			arg, _ := strconv.Unquote(quoted)
+			args, line = append(args, arg), line[len(quoted):]
+			continue
+		}
+		arg, rest, _ := strings.Cut(line, " ")
+		args, line = append(args, arg), rest
+	}
+	return args
+}
+
+// TestCommandLineRoundTrip renders the params of a command line and parses
+// the rendered line back into the same params.
+func TestCommandLineRoundTrip(t *testing.T) {
+	for _, args := range [][]string{
+		{"telnet", "example.com", "6379", "--send", `PING "x"\r\n`, "--expect-regex", `^\+PONG`, "--count", "3", "--delay", "50"},
+		{"telnet", "example.com", "53", "--udp", "--payload", "16", "--expect", "ok", "--timeout", "2"},
+		{"ping", "192.0.2.1", "--tcp", "22", "--count", "4", "--throttle"},
+		{"ping", "192.0.2.1", "--fallback-port", "0", "--payload", "56"},
+		{"trace", "example.com", "--tcp", "443", "-q", "2", "--max-hops", "12"},
+		{"mtu", "example.com", "--max-mtu", "9000", "--count", "3"},
+		{"nmap", "192.0.2.1", "--from", "20", "--to", "25", "--udp"},
+		{"web", "https://example.com/api", "-X", "POST", "-H", "accept: application/json", "-P", `{"a":1}`, "-W", "--http2", "--reuse", "-o", "body.json", "--max-body", "1024", "-u", "admin:secret", "--digest"},
+		{"web", "http://example.com", "--h2c", "--form", "a=1", "--form", "b=2", "--output-dir", "bodies", "--oauth2-token-url", "https://auth.example.com/token", "--oauth2-client-id", "shint", "--oauth2-client-secret", "s3cret", "--oauth2-scope", "read", "--oauth2-scope", "write"},
+		{"web", "https://example.com/upload", "-F", "note=hello world", "--http3", "--count", "2"},
+	} {
+		want := parseCommandLine(t, args)
+		line := lib.CommandLine(want)
+		if got := parseCommandLine(t, splitCommandLine(t, line)[1:]); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s parses into\n%+v\nexpected\n%+v", line, got, want)
+		}
+	}
+}